@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Cookie of the session id, set by the connect requests
+const sessionCookie = "session_id"
+
+// ApiRequest is the router independent part of a request of the REST API
+type ApiRequest struct {
+	ctx context.Context
+	// path parameters of the route, e.g. id of /ptz/cameras/:id
+	params map[string]string
+	query url.Values
+	header http.Header
+	body io.Reader
+	// session of the cookie, set for the routes with Session
+	session *Session
+	// session id to set in the cookie of the response, "" to keep and "-" to remove the cookie
+	cookie string
+}
+
+// ApiRoute is a route of the REST API, Path has ":name" segments and a final "*name" for the
+// rest of the path. Handle returns the JSON response with its HTTP status in "code", Stream
+// writes the response itself, e.g. Server-Sent Events
+type ApiRoute struct {
+	Method string
+	Path string
+	Session bool
+	Handle func(req *ApiRequest) map[string]interface{}
+	Stream func(req *ApiRequest, w http.ResponseWriter)
+}
+
+// Internal
+
+func (req *ApiRequest) Param(name string) string {
+	return req.params[name]
+}
+
+// Decode the JSON body into v
+func (req *ApiRequest) decode(v interface{}) error {
+	return json.NewDecoder(req.body).Decode(v)
+}
+
+// Number of the query parameter name in [min, max], def if it is missing
+func (req *ApiRequest) queryFloat(name string, def float64, min float64, max float64) (float64, error) {
+	value := req.query.Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < min || number > max {
+		return 0, fmt.Errorf("%s must be a number in [%g, %g]", name, min, max)
+	}
+
+	return number, nil
+}
+
+func apiResponse(code int, message string, data interface{}) map[string]interface{} {
+	return map[string]interface{}{"code": code, "message": message, "data": data}
+}
+
+func invalidRequest(err error) map[string]interface{} {
+	return apiResponse(http.StatusBadRequest, "Invalid request parameters: " + err.Error(), nil)
+}
+
+func errorResponse(err error) map[string]interface{} {
+	return apiResponse(errorCode(err), err.Error(), nil)
+}
+
+// write the response with HTTP status of its code
+func writeResponse(w http.ResponseWriter, res map[string]interface{}) {
+	code, ok := res["code"].(int)
+	if !ok {
+		code = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(&res)
+}
+
+// Match path to the pattern of a route, the "*name" parameter may be empty
+func matchRoute(pattern string, path string) (map[string]string, bool) {
+	params := make(map[string]string)
+	parts := strings.Split(strings.Trim(pattern, "/"), "/")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i, part := range parts {
+		if strings.HasPrefix(part, "*") {
+			if i > len(segments) {
+				return nil, false
+			}
+			params[part[1:]] = strings.Join(segments[i:], "/")
+			return params, true
+		}
+
+		if i >= len(segments) {
+			return nil, false
+		}
+
+		if strings.HasPrefix(part, ":") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			params[part[1:]] = segments[i]
+			continue
+		}
+
+		if part != segments[i] {
+			return nil, false
+		}
+	}
+
+	return params, len(parts) == len(segments)
+}
+
+// serveApi runs route for the request r, it is shared by the net/http and gin servers
+func serveApi(route ApiRoute, params map[string]string, w http.ResponseWriter, r *http.Request) {
+	req := &ApiRequest{ctx: r.Context(), params: params, query: r.URL.Query(), header: r.Header, body: r.Body}
+
+	if route.Session {
+		cookie, err := r.Cookie(sessionCookie)
+		if err != nil {
+			writeResponse(w, apiResponse(http.StatusUnauthorized, "Unauthorized", nil))
+			return
+		}
+
+		// an unknown or expired session is connected again by the client
+		session, err := gSessions.Get(cookie.Value)
+		if err != nil {
+			writeResponse(w, apiResponse(http.StatusUnauthorized, "Session not found", nil))
+			return
+		}
+
+		session.ActivateSession()
+		req.session = session
+	}
+
+	if route.Stream != nil {
+		route.Stream(req, w)
+		return
+	}
+
+	res := route.Handle(req)
+
+	switch req.cookie {
+	case "":
+	case "-":
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	default:
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: req.cookie, Path: "/", HttpOnly: true})
+	}
+
+	writeResponse(w, res)
+}
+
+// startSession adds the client to the session of the camera, a new session is started with start
+func startSession(req *ApiRequest, ip string, port uint16, start func() (*Session, error)) map[string]interface{} {
+	session, err := gSessions.Acquire(ip, port, start)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	req.cookie = session.id
+
+	return apiResponse(http.StatusOK, "Session started", SessionID{Id: session.id})
+}
+
+// apiHandler serves the routes with net/http, a method without route is not found
+type apiHandler struct {
+	routes []ApiRoute
+}
+
+func (handler apiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, route := range handler.routes {
+		if route.Method != r.Method {
+			continue
+		}
+
+		if params, ok := matchRoute(route.Path, r.URL.Path); ok {
+			serveApi(route, params, w, r)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, "404 page not found")
+}
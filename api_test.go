@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Routes which decode a JSON body
+var jsonRoutes = map[string]bool{
+	"POST /ptz/connect": true,
+	"POST /ptz/inventory/:bucket/*key": true,
+	"POST /ptz/profile": true,
+	"POST /ptz/move/relative": true,
+	"POST /ptz/move/continuous": true,
+	"POST /ptz/goto/position": true,
+	"POST /ptz/goto/preset": true,
+	"POST /ptz/preset/set": true,
+	"POST /ptz/preset/remove": true,
+	"POST /ptz/aux": true,
+	"POST /ptz/tour/save": true,
+	"POST /ptz/tour/remove": true,
+	"POST /ptz/tour/start": true,
+	"POST /ptz/presettour/create": true,
+	"POST /ptz/presettour/modify": true,
+	"POST /ptz/presettour/operate": true,
+	"POST /ptz/presettour/remove": true,
+	"POST /ptz/imaging/settings": true,
+	"POST /ptz/imaging/move": true,
+}
+
+// Both servers of the API, they must answer alike
+func testServers(t *testing.T) map[string]http.Handler {
+	t.Helper()
+
+	gServer.LogLevel = LogError
+	gin.SetMode(gin.TestMode)
+
+	return map[string]http.Handler{
+		"net/http": apiHandler{routes: apiRoutes},
+		"gin": ginRouter(),
+	}
+}
+
+// Path of the route with its parameters filled in
+func routePath(route ApiRoute) string {
+	path := strings.Replace(route.Path, ":id", "cam1", 1)
+	path = strings.Replace(path, ":bucket", "presets", 1)
+	return strings.Replace(path, "*key", "cam1/home", 1)
+}
+
+// Session of a camera without connection and stream, added to gSessions
+func acquireTestSession(t *testing.T, ip string) *Session {
+	t.Helper()
+
+	session, err := gSessions.Acquire(ip, 80, func() (*Session, error) {
+		return newSession(newTestControl(ip)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return session
+}
+
+func serveTest(handler http.Handler, method string, path string, body string, cookie string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if cookie != "" {
+		req.AddCookie(&http.Cookie{Name: sessionCookie, Value: cookie})
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// Code of the JSON response, the HTTP status must be the same
+func checkStatus(t *testing.T, name string, rec *httptest.ResponseRecorder, code int) {
+	t.Helper()
+
+	if rec.Code != code {
+		t.Errorf("%s: status %d, want %d", name, rec.Code, code)
+		return
+	}
+
+	var res map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Errorf("%s: %v", name, err)
+		return
+	}
+	if res["code"] != float64(code) {
+		t.Errorf("%s: code %v, want %d", name, res["code"], code)
+	}
+}
+
+func TestJsonRoutesExist(t *testing.T) {
+	routes := make(map[string]bool)
+	for _, route := range apiRoutes {
+		routes[route.Method + " " + route.Path] = true
+	}
+
+	for route := range jsonRoutes {
+		if !routes[route] {
+			t.Errorf("%s is not a route", route)
+		}
+	}
+}
+
+func TestApiWrongMethod(t *testing.T) {
+	for name, handler := range testServers(t) {
+		for _, route := range apiRoutes {
+			rec := serveTest(handler, http.MethodPatch, routePath(route), "", "")
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("%s PATCH %s: status %d, want 404", name, route.Path, rec.Code)
+			}
+		}
+	}
+}
+
+func TestApiMissingCookie(t *testing.T) {
+	for name, handler := range testServers(t) {
+		for _, route := range apiRoutes {
+			if !route.Session {
+				continue
+			}
+			rec := serveTest(handler, route.Method, routePath(route), "{}", "")
+			checkStatus(t, name + " " + route.Method + " " + route.Path, rec, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestApiUnknownSession(t *testing.T) {
+	for name, handler := range testServers(t) {
+		for _, route := range apiRoutes {
+			if !route.Session {
+				continue
+			}
+			rec := serveTest(handler, route.Method, routePath(route), "{}", "unknown")
+			checkStatus(t, name + " " + route.Method + " " + route.Path, rec, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestApiExpiredSession(t *testing.T) {
+	session := acquireTestSession(t, "192.0.2.18")
+
+	rec := serveTest(apiHandler{routes: apiRoutes}, "GET", "/ptz/stream", "", session.id)
+	checkStatus(t, "active session", rec, http.StatusOK)
+
+	session.state.Lock()
+	session.last_time = time.Now().Add(-2 * gServer.Timeout())
+	session.state.Unlock()
+	gSessions.Expire()
+
+	for name, handler := range testServers(t) {
+		rec := serveTest(handler, "GET", "/ptz/stream", "", session.id)
+		checkStatus(t, name + " expired session", rec, http.StatusUnauthorized)
+	}
+}
+
+func TestApiBadJson(t *testing.T) {
+	session := acquireTestSession(t, "192.0.2.19")
+	defer gSessions.Release(session.id)
+
+	for name, handler := range testServers(t) {
+		for _, route := range apiRoutes {
+			if !jsonRoutes[route.Method + " " + route.Path] {
+				continue
+			}
+			rec := serveTest(handler, route.Method, routePath(route), "{bad", session.id)
+			checkStatus(t, name + " " + route.Method + " " + route.Path, rec, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestApiStatus(t *testing.T) {
+	tests := []struct {
+		method string
+		path string
+		code int
+	}{
+		{"GET", "/ptz", http.StatusOK},
+		{"GET", "/ptz/cameras", http.StatusOK},
+		{"GET", "/ptz/cameras/unknown", http.StatusNotFound},
+		{"POST", "/ptz/cameras/unknown/connect", http.StatusNotFound},
+		{"GET", "/ptz/discover?timeout=abc", http.StatusBadRequest},
+		{"GET", "/ptz/discover?timeout=3600", http.StatusBadRequest},
+	}
+
+	for name, handler := range testServers(t) {
+		for _, test := range tests {
+			rec := serveTest(handler, test.method, test.path, "", "")
+			if rec.Code != test.code {
+				t.Errorf("%s %s %s: status %d, want %d", name, test.method, test.path, rec.Code, test.code)
+			}
+		}
+	}
+}
+
+func TestWriteResponse(t *testing.T) {
+	tests := []struct {
+		res map[string]interface{}
+		code int
+	}{
+		{apiResponse(http.StatusOK, "OK", nil), http.StatusOK},
+		{apiResponse(http.StatusServiceUnavailable, "Cannot connect", nil), http.StatusServiceUnavailable},
+		{errorResponse(ErrNotFound), http.StatusNotFound},
+		{invalidRequest(ErrInvalidArgument), http.StatusBadRequest},
+		{map[string]interface{}{"message": "PTZ Server"}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		rec := httptest.NewRecorder()
+		writeResponse(rec, test.res)
+
+		if rec.Code != test.code {
+			t.Errorf("%v: status %d, want %d", test.res, rec.Code, test.code)
+		}
+		if contentType := rec.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%v: content type %s", test.res, contentType)
+		}
+
+		var res map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || res["message"] != test.res["message"] {
+			t.Errorf("%v: body %s", test.res, rec.Body.String())
+		}
+	}
+}
+
+func TestSessionIdJson(t *testing.T) {
+	data, _ := json.Marshal(SessionID{Id: "abc"})
+	if string(data) != `{"` + sessionCookie + `":"abc"}` {
+		t.Errorf("SessionID is %s", data)
+	}
+}
+
+func TestWriteSnapshotQuality(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100})
+	snapshot := &Snapshot{Data: buf.Bytes(), ContentType: "image/jpeg", Time: time.Now()}
+	req := &ApiRequest{header: http.Header{}}
+
+	// the camera image is sent as is
+	rec := httptest.NewRecorder()
+	writeSnapshot(req, rec, snapshotQuery{quality: 80}, snapshot, "cam1")
+	if !bytes.Equal(rec.Body.Bytes(), snapshot.Data) {
+		t.Error("camera image encoded again without quality")
+	}
+
+	// a given quality encodes it again
+	rec = httptest.NewRecorder()
+	writeSnapshot(req, rec, snapshotQuery{quality: 10, encode: true}, snapshot, "cam1")
+	if rec.Code != http.StatusOK || rec.Body.Len() >= len(snapshot.Data) {
+		t.Errorf("quality 10: status %d, %d bytes of %d", rec.Code, rec.Body.Len(), len(snapshot.Data))
+	}
+}
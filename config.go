@@ -1,25 +1,273 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"strconv"
+	"time"
 	"gopkg.in/yaml.v3"
 )
 
+const configFile = "config.yaml"
+
+// Log levels of ServerConfig
+const (
+	LogDebug = "debug"
+	LogInfo = "info"
+	LogWarn = "warn"
+	LogError = "error"
+)
+
+// ServerConfig is the web server part of the config file, it is overridden by the PTZ_*
+// environment variables and then by the command line flags
+type ServerConfig struct {
+	// host:port
+	Listen string `yaml:"listen"`
+	// HTTPS if both are set
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey string `yaml:"tls_key"`
+	// load the certificate again when its files change
+	TLSReload bool `yaml:"tls_reload"`
+	StaticDir string `yaml:"static_dir"`
+	// seconds without request before a session ends
+	SessionTimeout int `yaml:"session_timeout"`
+	// debug, info, warn or error
+	LogLevel string `yaml:"log_level"`
+}
+
+// ServerFlags are the command line flags, e.g. ./ptz_go -listen :8443 -tls-cert cert.pem -tls-key key.pem
+type ServerFlags struct {
+	set *flag.FlagSet
+	// config file
+	Config string
+	server ServerConfig
+}
+
+// Camera of the config file, cameras are addressed by Id in the REST API
+type CameraConfig struct {
+	Id string `yaml:"id" json:"id"`
+	Name string `yaml:"name" json:"name"`
+	Ip string `yaml:"ip" json:"ip"`
+	Port uint16 `yaml:"port" json:"port"`
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"-"`
+	// default profile, the first profile if empty
+	Profile string `yaml:"profile" json:"profile"`
+	Labels map[string]string `yaml:"labels" json:"labels"`
+}
+
+// Config is the config file, a single camera (ip, port, username, password) is still accepted
+type Config struct {
+	PTZInfo `yaml:",inline"`
+	Cameras []CameraConfig `yaml:"cameras"`
+	Store StoreConfig `yaml:"store"`
+	Secrets SecretsConfig `yaml:"secrets"`
+	Server ServerConfig `yaml:"server"`
+	// file of the config, set by LoadConfigFile
+	Path string `yaml:"-"`
+}
+
+func defaultServerConfig() ServerConfig {
+	return ServerConfig{Listen: ":8000", StaticDir: "static", SessionTimeout: 30, LogLevel: LogInfo}
+}
+
+func validateServerConfig(config ServerConfig) error {
+	if (config.TLSCert == "") != (config.TLSKey == "") {
+		return fmt.Errorf("%w: tls_cert and tls_key are both required", ErrInvalidArgument)
+	}
+
+	if config.SessionTimeout <= 0 {
+		return fmt.Errorf("%w: session_timeout %d", ErrOutOfRange, config.SessionTimeout)
+	}
+
+	switch config.LogLevel {
+	case LogDebug, LogInfo, LogWarn, LogError:
+	default:
+		return fmt.Errorf("%w: log_level %q", ErrInvalidArgument, config.LogLevel)
+	}
+
+	return nil
+}
+
+// Override config with the set PTZ_* environment variables
+func applyServerEnv(config *ServerConfig) error {
+	values := map[string]*string{
+		"PTZ_LISTEN": &config.Listen,
+		"PTZ_TLS_CERT": &config.TLSCert,
+		"PTZ_TLS_KEY": &config.TLSKey,
+		"PTZ_STATIC_DIR": &config.StaticDir,
+		"PTZ_LOG_LEVEL": &config.LogLevel,
+	}
+	for name, value := range values {
+		if env, ok := os.LookupEnv(name); ok {
+			*value = env
+		}
+	}
+
+	if env, ok := os.LookupEnv("PTZ_TLS_RELOAD"); ok {
+		reload, err := strconv.ParseBool(env)
+		if err != nil {
+			return fmt.Errorf("%w: PTZ_TLS_RELOAD %q", ErrInvalidArgument, env)
+		}
+		config.TLSReload = reload
+	}
+
+	if env, ok := os.LookupEnv("PTZ_SESSION_TIMEOUT"); ok {
+		timeout, err := strconv.Atoi(env)
+		if err != nil {
+			return fmt.Errorf("%w: PTZ_SESSION_TIMEOUT %q", ErrInvalidArgument, env)
+		}
+		config.SessionTimeout = timeout
+	}
+
+	return nil
+}
+
+// NewServerFlags defines the command line flags, the config file is PTZ_CONFIG or config.yaml by default
+func NewServerFlags() *ServerFlags {
+	flags := &ServerFlags{set: flag.NewFlagSet("ptz_go", flag.ContinueOnError)}
+
+	config := os.Getenv("PTZ_CONFIG")
+	if config == "" {
+		config = configFile
+	}
+
+	flags.set.StringVar(&flags.Config, "config", config, "config file")
+	flags.set.StringVar(&flags.server.Listen, "listen", "", "listen address, e.g. :8000")
+	flags.set.StringVar(&flags.server.TLSCert, "tls-cert", "", "TLS certificate file")
+	flags.set.StringVar(&flags.server.TLSKey, "tls-key", "", "TLS key file")
+	flags.set.BoolVar(&flags.server.TLSReload, "tls-reload", false, "reload the TLS certificate when it changes")
+	flags.set.StringVar(&flags.server.StaticDir, "static", "", "static files directory")
+	flags.set.IntVar(&flags.server.SessionTimeout, "session-timeout", 0, "session timeout in seconds")
+	flags.set.StringVar(&flags.server.LogLevel, "log-level", "", "debug, info, warn or error")
+
+	return flags
+}
+
+func (flags *ServerFlags) Parse(args []string) error {
+	return flags.set.Parse(args)
+}
+
+// LoadServerConfig applies the defaults, the environment and the set flags to the config file
+func LoadServerConfig(config ServerConfig, flags *ServerFlags) (ServerConfig, error) {
+	server := defaultServerConfig()
+
+	if config.Listen != "" {
+		server.Listen = config.Listen
+	}
+	server.TLSCert, server.TLSKey, server.TLSReload = config.TLSCert, config.TLSKey, config.TLSReload
+	if config.StaticDir != "" {
+		server.StaticDir = config.StaticDir
+	}
+	if config.SessionTimeout != 0 {
+		server.SessionTimeout = config.SessionTimeout
+	}
+	if config.LogLevel != "" {
+		server.LogLevel = config.LogLevel
+	}
+
+	if err := applyServerEnv(&server); err != nil {
+		return server, err
+	}
+
+	flags.set.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			server.Listen = flags.server.Listen
+		case "tls-cert":
+			server.TLSCert = flags.server.TLSCert
+		case "tls-key":
+			server.TLSKey = flags.server.TLSKey
+		case "tls-reload":
+			server.TLSReload = flags.server.TLSReload
+		case "static":
+			server.StaticDir = flags.server.StaticDir
+		case "session-timeout":
+			server.SessionTimeout = flags.server.SessionTimeout
+		case "log-level":
+			server.LogLevel = flags.server.LogLevel
+		}
+	})
+
+	return server, validateServerConfig(server)
+}
+
+// Timeout of the sessions
+func (config ServerConfig) Timeout() time.Duration {
+	return time.Duration(config.SessionTimeout) * time.Second
+}
+
+func LoadConfigFile(path string) (Config, error) {
+	config := Config{Path: path}
+
+	dataBytes, err := os.ReadFile(path)
+	if err != nil {
+		logWarn("Failed to load config file " + path + ":", err)
+		return config, err
+	}
+
+	err = yaml.Unmarshal(dataBytes, &config)
+	if err != nil {
+		logError("Failed to parse config file " + path + ":", err)
+		return config, err
+	}
+
+	// single camera config
+	if len(config.Cameras) == 0 && config.Ip != "" {
+		config.Cameras = []CameraConfig{{Id: "default", Ip: config.Ip, Port: config.Port, Username: config.Username, Password: config.Password}}
+	}
+
+	ids := make(map[string]bool)
+	for i := range config.Cameras {
+		camera := &config.Cameras[i]
+
+		if camera.Id == "" || camera.Ip == "" {
+			err := fmt.Errorf("%w: camera %d needs id and ip", ErrInvalidArgument, i)
+			logError("Failed to parse config file " + path + ":", err)
+			return config, err
+		}
+		if ids[camera.Id] {
+			err := fmt.Errorf("%w: duplicate camera id %s", ErrInvalidArgument, camera.Id)
+			logError("Failed to parse config file " + path + ":", err)
+			return config, err
+		}
+		ids[camera.Id] = true
+
+		if camera.Port == 0 {
+			camera.Port = 80
+		}
+		if camera.Name == "" {
+			camera.Name = camera.Id
+		}
+	}
+
+	return config, nil
+}
+
+// LoadConfig returns the first camera of the config file
 func LoadConfig() (PTZInfo, error) {
 	info := PTZInfo{}
 
-	dataBytes, err := os.ReadFile("config.yaml")
+	config, err := LoadConfigFile(configFile)
 	if err != nil {
-		fmt.Println("Failed to load config file.", err)
 		return info, err
 	}
 
-	err = yaml.Unmarshal(dataBytes, &info)
-	if err != nil {
-		fmt.Println("Failed to parse config file.", err)
-		return info, err
+	if len(config.Cameras) == 0 {
+		return info, fmt.Errorf("%w: no camera in %s", ErrNotFound, configFile)
+	}
+
+	camera := config.Cameras[0]
+
+	// the password is kept in the secrets file after the first start
+	if camera.Password == "" {
+		secrets, err := OpenSecrets(config.Secrets)
+		if err != nil {
+			return info, err
+		}
+		camera.Password = secrets.Get(camera.Id)
 	}
 
-	return info, nil
-}
\ No newline at end of file
+	return PTZInfo{Ip: camera.Ip, Port: camera.Port, Username: camera.Username, Password: camera.Password}, nil
+}
@@ -0,0 +1,39 @@
+//go:build !cgo
+// +build !cgo
+
+package main
+
+import (
+	"fmt"
+	"image"
+)
+
+// Without cgo there is no libavcodec, the streams are not decoded and snapshots are
+// taken by the camera (GetSnapshotUri)
+var errNoDecoder = fmt.Errorf("%w: video decoding needs cgo and libavcodec", ErrUnsupported)
+
+type h264Decoder struct{}
+
+func (d *h264Decoder) initialize() error {
+	return errNoDecoder
+}
+
+func (d *h264Decoder) close() {
+}
+
+func (d *h264Decoder) decode(nalu []byte) (image.Image, error) {
+	return nil, errNoDecoder
+}
+
+type h265Decoder struct{}
+
+func (d *h265Decoder) initialize() error {
+	return errNoDecoder
+}
+
+func (d *h265Decoder) close() {
+}
+
+func (d *h265Decoder) decode(nalu []byte) (image.Image, error) {
+	return nil, errNoDecoder
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/google/uuid"
+)
+
+// WS-Discovery multicast address of ONVIF devices
+const discoveryAddress = "239.255.255.250:3702"
+
+// Default and maximum time to wait for ProbeMatches
+const (
+	discoveryTimeout = 3 * time.Second
+	discoveryMaxTimeout = 30 * time.Second
+)
+
+const probeTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dn="http://www.onvif.org/ver10/network/wsdl">
+<s:Header>
+<a:Action s:mustUnderstand="1">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</a:Action>
+<a:MessageID>uuid:%s</a:MessageID>
+<a:ReplyTo><a:Address>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>
+<a:To s:mustUnderstand="1">urn:schemas-xmlsoap-org:ws:2005:04:discovery</a:To>
+</s:Header>
+<s:Body><d:Probe><d:Types>dn:NetworkVideoTransmitter</d:Types></d:Probe></s:Body>
+</s:Envelope>`
+
+type DiscoveredDevice struct {
+	Address string
+	Ip string
+	Port uint16
+	XAddrs []string
+	Types []string
+	Scopes []string
+	Name string
+	Hardware string
+	Location string
+}
+
+// Parse a ProbeMatches message, a message may hold several matches
+func parseProbeMatches(data []byte) ([]DiscoveredDevice, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, err
+	}
+
+	if doc.Root() == nil {
+		return nil, &MissingFieldError{Path: "/Envelope"}
+	}
+
+	devices := make([]DiscoveredDevice, 0)
+	matches := doc.Root().FindElements("/Envelope/Body/ProbeMatches/ProbeMatch")
+
+	for _, match := range matches {
+		var device DiscoveredDevice
+
+		if address := match.FindElement("EndpointReference/Address"); address != nil {
+			device.Address = strings.TrimSpace(address.Text())
+		}
+		if xaddrs := match.SelectElement("XAddrs"); xaddrs != nil {
+			device.XAddrs = strings.Fields(xaddrs.Text())
+		}
+		if types := match.SelectElement("Types"); types != nil {
+			device.Types = strings.Fields(types.Text())
+		}
+		if scopes := match.SelectElement("Scopes"); scopes != nil {
+			device.Scopes = strings.Fields(scopes.Text())
+		}
+
+		// onvif://www.onvif.org/name/xxx, hardware/xxx and location/xxx
+		for _, scope := range device.Scopes {
+			for _, kind := range []string{"name", "hardware", "location"} {
+				prefix := "onvif://www.onvif.org/" + kind + "/"
+				if !strings.HasPrefix(scope, prefix) {
+					continue
+				}
+
+				value, err := url.PathUnescape(strings.TrimPrefix(scope, prefix))
+				if err != nil {
+					value = strings.TrimPrefix(scope, prefix)
+				}
+
+				switch kind {
+				case "name":
+					device.Name = value
+				case "hardware":
+					device.Hardware = value
+				case "location":
+					device.Location = value
+				}
+			}
+		}
+
+		// ip and port of the first device service address
+		for _, xaddr := range device.XAddrs {
+			u, err := url.Parse(xaddr)
+			if err != nil || u.Hostname() == "" {
+				continue
+			}
+
+			device.Ip = u.Hostname()
+			device.Port = 80
+			if p, err := strconv.ParseUint(u.Port(), 10, 16); err == nil {
+				device.Port = uint16(p)
+			}
+			break
+		}
+
+		if device.Address == "" && len(device.XAddrs) == 0 {
+			continue
+		}
+
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
+
+// Discover sends a WS-Discovery Probe to address (default multicast address if empty)
+// and collects ProbeMatches until timeout or ctx is done
+func Discover(ctx context.Context, address string, timeout time.Duration) ([]DiscoveredDevice, error) {
+	if address == "" {
+		address = discoveryAddress
+	}
+
+	if timeout <= 0 {
+		timeout = discoveryTimeout
+	}
+
+	dst, err := net.ResolveUDPAddr("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	probe := fmt.Sprintf(probeTemplate, uuid.New().String())
+	if _, err := conn.WriteToUDP([]byte(probe), dst); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetReadDeadline(deadline)
+
+	// unblock the read when ctx is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	devices := make([]DiscoveredDevice, 0)
+	seen := make(map[string]bool)
+	buf := make([]byte, 65536)
+
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return devices, err
+		}
+
+		matches, err := parseProbeMatches(buf[:n])
+		if err != nil {
+			// not a ProbeMatches message
+			continue
+		}
+
+		for _, device := range matches {
+			key := device.Address
+			if key == "" {
+				key = strings.Join(device.XAddrs, " ")
+			}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			devices = append(devices, device)
+		}
+	}
+
+	return devices, ctx.Err()
+}
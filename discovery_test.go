@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const probeMatchesTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://www.w3.org/2003/05/soap-envelope" xmlns:wsa="http://schemas.xmlsoap.org/ws/2004/08/addressing" xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery">
+<SOAP-ENV:Header><wsa:Action>http://schemas.xmlsoap.org/ws/2005/04/discovery/ProbeMatches</wsa:Action></SOAP-ENV:Header>
+<SOAP-ENV:Body><d:ProbeMatches>%s</d:ProbeMatches></SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+
+const probeMatchTemplate = `<d:ProbeMatch>
+<wsa:EndpointReference><wsa:Address>%s</wsa:Address></wsa:EndpointReference>
+<d:Types>dn:NetworkVideoTransmitter tds:Device</d:Types>
+<d:Scopes>%s</d:Scopes>
+<d:XAddrs>%s</d:XAddrs>
+</d:ProbeMatch>`
+
+func probeMatches(matches ...string) []byte {
+	return []byte(fmt.Sprintf(probeMatchesTemplate, strings.Join(matches, "")))
+}
+
+func probeMatch(address string, scopes string, xaddrs string) string {
+	return fmt.Sprintf(probeMatchTemplate, address, scopes, xaddrs)
+}
+
+func TestParseProbeMatches(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		devices []DiscoveredDevice
+		err bool
+	}{
+		{
+			name: "camera",
+			data: probeMatches(probeMatch("urn:uuid:1",
+				"onvif://www.onvif.org/name/Tapo%20C200 onvif://www.onvif.org/hardware/C200 onvif://www.onvif.org/location/Garden",
+				"http://192.168.1.10:2020/onvif/device_service http://[fe80::1]/onvif/device_service")),
+			devices: []DiscoveredDevice{{
+				Address: "urn:uuid:1",
+				Ip: "192.168.1.10",
+				Port: 2020,
+				XAddrs: []string{"http://192.168.1.10:2020/onvif/device_service", "http://[fe80::1]/onvif/device_service"},
+				Types: []string{"dn:NetworkVideoTransmitter", "tds:Device"},
+				Scopes: []string{"onvif://www.onvif.org/name/Tapo%20C200", "onvif://www.onvif.org/hardware/C200", "onvif://www.onvif.org/location/Garden"},
+				Name: "Tapo C200",
+				Hardware: "C200",
+				Location: "Garden",
+			}},
+		},
+		{
+			name: "default port and several matches",
+			data: probeMatches(
+				probeMatch("urn:uuid:2", "", "http://192.168.1.11/onvif/device_service"),
+				probeMatch("urn:uuid:3", "", "not-a-url http://192.168.1.12:8080/onvif")),
+			devices: []DiscoveredDevice{
+				{Address: "urn:uuid:2", Ip: "192.168.1.11", Port: 80, XAddrs: []string{"http://192.168.1.11/onvif/device_service"}, Types: []string{"dn:NetworkVideoTransmitter", "tds:Device"}, Scopes: []string{}},
+				{Address: "urn:uuid:3", Ip: "192.168.1.12", Port: 8080, XAddrs: []string{"not-a-url", "http://192.168.1.12:8080/onvif"}, Types: []string{"dn:NetworkVideoTransmitter", "tds:Device"}, Scopes: []string{}},
+			},
+		},
+		{
+			name: "match without address",
+			data: probeMatches(probeMatch("", "", "")),
+			devices: []DiscoveredDevice{},
+		},
+		{
+			name: "other message",
+			data: []byte(`<Envelope><Body><Probe/></Body></Envelope>`),
+			devices: []DiscoveredDevice{},
+		},
+		{
+			name: "malformed xml",
+			data: []byte(`<Envelope><Body><ProbeMatches>`),
+			err: true,
+		},
+		{
+			name: "empty",
+			data: []byte(``),
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		devices, err := parseProbeMatches(test.data)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(devices, test.devices) {
+			t.Errorf("%s: %+v, want %+v", test.name, devices, test.devices)
+		}
+	}
+}
+
+// Responder answers each Probe with the messages, like the cameras of a network. Discover is
+// tested by unicast to the responder, the multicast address is not tested since test machines
+// often have no multicast route
+func startResponder(t *testing.T, messages ...[]byte) *net.UDPConn {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, src, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if !bytes.Contains(buf[:n], []byte("<d:Probe>")) {
+				continue
+			}
+			for _, message := range messages {
+				conn.WriteToUDP(message, src)
+			}
+		}
+	}()
+
+	return conn
+}
+
+func TestDiscover(t *testing.T) {
+	camera := probeMatch("urn:uuid:10", "onvif://www.onvif.org/name/Cam1 onvif://www.onvif.org/hardware/HW1", "http://127.0.0.1:8000/onvif/device_service")
+
+	responder := startResponder(t,
+		probeMatches(camera),
+		[]byte("not a ProbeMatches message"),
+		// the same camera answers again and a camera without address
+		probeMatches(camera, probeMatch("", "", "http://127.0.0.2/onvif/device_service")),
+		probeMatches(probeMatch("", "", "http://127.0.0.2/onvif/device_service")),
+	)
+	defer responder.Close()
+
+	start := time.Now()
+	devices, err := Discover(context.Background(), responder.LocalAddr().String(), 300 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2 * time.Second {
+		t.Errorf("Discover took %v", elapsed)
+	}
+
+	if len(devices) != 2 {
+		t.Fatalf("%d devices, want 2: %+v", len(devices), devices)
+	}
+
+	device := devices[0]
+	if device.Address != "urn:uuid:10" || device.Ip != "127.0.0.1" || device.Port != 8000 || device.Name != "Cam1" || device.Hardware != "HW1" {
+		t.Errorf("device %+v", device)
+	}
+	if !reflect.DeepEqual(device.XAddrs, []string{"http://127.0.0.1:8000/onvif/device_service"}) {
+		t.Errorf("XAddrs %v", device.XAddrs)
+	}
+	if devices[1].Ip != "127.0.0.2" || devices[1].Port != 80 {
+		t.Errorf("device without address %+v", devices[1])
+	}
+}
+
+func TestDiscoverCanceled(t *testing.T) {
+	responder := startResponder(t)
+	defer responder.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	devices, err := Discover(ctx, responder.LocalAddr().String(), 10 * time.Second)
+	if err != context.Canceled || len(devices) != 0 {
+		t.Errorf("Discover: %v, %v", devices, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2 * time.Second {
+		t.Errorf("canceled Discover took %v", elapsed)
+	}
+}
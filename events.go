@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+// Lifetime of a PullPoint subscription, it is renewed before it expires
+const subscriptionTerminationTime = 60 * time.Second
+
+// Long polling time of PullMessages, must be less than callTimeout
+const pullTimeout = 5 * time.Second
+
+const pullMessageLimit = 16
+
+// Events buffered for each client, events are dropped for slow clients
+const eventBuffer = 32
+
+// Interval of Server-Sent Events comments keeping the connection and the session alive
+const eventKeepAlive = 15 * time.Second
+
+// Event is a parsed ONVIF notification, e.g. topic tns1:RuleEngine/CellMotionDetector/Motion
+// with Source {VideoSourceConfigurationToken: ...} and Data {IsMotion: true}
+type Event struct {
+	Topic string
+	Time time.Time
+	// Initialized, Changed or Deleted
+	Operation string
+	Source map[string]string
+	Key map[string]string
+	Data map[string]string
+}
+
+// EventSubscription is a PullPoint subscription of the Events service, pulled events
+// are fanned out to all clients
+type EventSubscription struct {
+	dev *onvifDevice
+	address string
+	termination time.Time
+	clients map[chan Event]bool
+	lock *sync.Mutex
+	cancel context.CancelFunc
+	done chan struct{}
+}
+
+type createPullPointSubscription struct {
+	XMLName string `xml:"tev:CreatePullPointSubscription"`
+	InitialTerminationTime string `xml:"tev:InitialTerminationTime"`
+}
+
+type pullMessages struct {
+	XMLName string `xml:"tev:PullMessages"`
+	Timeout string `xml:"tev:Timeout"`
+	MessageLimit int `xml:"tev:MessageLimit"`
+}
+
+type renewSubscription struct {
+	XMLName string `xml:"wsnt:Renew"`
+	TerminationTime string `xml:"wsnt:TerminationTime"`
+}
+
+type unsubscribe struct {
+	XMLName string `xml:"wsnt:Unsubscribe"`
+}
+
+// Internal
+
+// Parse NotificationMessage elements of PullMessagesResponse
+func parseNotifications(doc *etree.Document) ([]Event, error) {
+	r := fieldReader{}
+	response := r.element(doc.Root(), "/Envelope/Body/PullMessagesResponse")
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	events := make([]Event, 0)
+
+	for _, notification := range response.SelectElements("NotificationMessage") {
+		var event Event
+
+		if topic := notification.SelectElement("Topic"); topic != nil {
+			event.Topic = strings.TrimSpace(topic.Text())
+		}
+
+		message := notification.FindElement("Message/Message")
+		if message == nil {
+			continue
+		}
+
+		if t, err := time.Parse(time.RFC3339, message.SelectAttrValue("UtcTime", "")); err == nil {
+			event.Time = t
+		}
+		event.Operation = message.SelectAttrValue("PropertyOperation", "")
+
+		event.Source = parseSimpleItems(message.SelectElement("Source"))
+		event.Key = parseSimpleItems(message.SelectElement("Key"))
+		event.Data = parseSimpleItems(message.SelectElement("Data"))
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func parseSimpleItems(node *etree.Element) map[string]string {
+	items := make(map[string]string)
+	if node == nil {
+		return items
+	}
+
+	for _, item := range node.SelectElements("SimpleItem") {
+		items[item.SelectAttrValue("Name", "")] = item.SelectAttrValue("Value", "")
+	}
+
+	return items
+}
+
+func createSubscription(ctx context.Context, dev *onvifDevice) (string, time.Time, error) {
+	create := createPullPointSubscription{InitialTerminationTime: formatDuration(subscriptionTerminationTime.Seconds())}
+	doc, err := dev.callAt(ctx, "event", create)
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	r := fieldReader{}
+	address := r.text(doc.Root(), "/Envelope/Body/CreatePullPointSubscriptionResponse/SubscriptionReference/Address")
+
+	if r.err != nil {
+		return "", time.Time{}, r.err
+	}
+
+	return subscriptionAddress(address, dev.info.Ip), terminationTime(doc, "CreatePullPointSubscriptionResponse"), nil
+}
+
+// The subscription address may have the internal host of the camera like the service endpoints,
+// a different host is replaced with the host of the device and the advertised port is kept
+func subscriptionAddress(address string, ip string) string {
+	u, err := url.Parse(address)
+	if err != nil || u.Host == "" || u.Hostname() == ip {
+		return address
+	}
+
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(ip, port)
+	} else if strings.Contains(ip, ":") {
+		u.Host = "[" + ip + "]"
+	} else {
+		u.Host = ip
+	}
+
+	return u.String()
+}
+
+// Termination time of the response, the camera clock may differ, so the local time is used if missing
+func terminationTime(doc *etree.Document, response string) time.Time {
+	termination := time.Now().Add(subscriptionTerminationTime)
+
+	current := doc.Root().FindElement("/Envelope/Body/" + response + "/CurrentTime")
+	end := doc.Root().FindElement("/Envelope/Body/" + response + "/TerminationTime")
+	if current == nil || end == nil {
+		return termination
+	}
+
+	c, err1 := time.Parse(time.RFC3339, strings.TrimSpace(current.Text()))
+	e, err2 := time.Parse(time.RFC3339, strings.TrimSpace(end.Text()))
+	if err1 != nil || err2 != nil || !e.After(c) {
+		return termination
+	}
+
+	return time.Now().Add(e.Sub(c))
+}
+
+func pullEvents(ctx context.Context, dev *onvifDevice, address string) ([]Event, error) {
+	pull := pullMessages{Timeout: formatDuration(pullTimeout.Seconds()), MessageLimit: pullMessageLimit}
+	doc, err := dev.callAddress(ctx, address, pull)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseNotifications(doc)
+}
+
+func renewEvents(ctx context.Context, dev *onvifDevice, address string) (time.Time, error) {
+	renew := renewSubscription{TerminationTime: formatDuration(subscriptionTerminationTime.Seconds())}
+	doc, err := dev.callAddress(ctx, address, renew)
+
+	if err := checkResponse(doc, err, "RenewResponse"); err != nil {
+		return time.Time{}, err
+	}
+
+	return terminationTime(doc, "RenewResponse"), nil
+}
+
+func unsubscribeEvents(ctx context.Context, dev *onvifDevice, address string) (error) {
+	doc, err := dev.callAddress(ctx, address, unsubscribe{})
+
+	return checkResponse(doc, err, "UnsubscribeResponse")
+}
+
+// Pull events until the subscription is closed, the subscription is renewed before it
+// expires and created again if the camera dropped it
+func (sub *EventSubscription) run(ctx context.Context) {
+	defer close(sub.done)
+
+	for ctx.Err() == nil {
+		if time.Until(sub.termination) < subscriptionTerminationTime/3 {
+			termination, err := renewEvents(ctx, sub.dev, sub.address)
+			if err == nil {
+				sub.termination = termination
+			} else if ctx.Err() == nil {
+				logWarn("Cannot renew event subscription:", err)
+				sub.resubscribe(ctx)
+				continue
+			}
+		}
+
+		events, err := pullEvents(ctx, sub.dev, sub.address)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			logWarn("Cannot pull events:", err)
+			sub.resubscribe(ctx)
+			continue
+		}
+
+		for _, event := range events {
+			sub.publish(event)
+		}
+	}
+}
+
+// Create a new subscription after an error, retried every second until ctx is done
+func (sub *EventSubscription) resubscribe(ctx context.Context) {
+	// cameras have few PullPoints, the old one is removed if the camera still has it
+	unsubscribeEvents(ctx, sub.dev, sub.address)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+
+		address, termination, err := createSubscription(ctx, sub.dev)
+		if err == nil {
+			sub.address = address
+			sub.termination = termination
+			return
+		}
+	}
+}
+
+func (sub *EventSubscription) publish(event Event) {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+
+	for client := range sub.clients {
+		select {
+		case client <- event:
+		default:
+			// slow client
+		}
+	}
+}
+
+// Interface for Outside
+
+// NewEventSubscription creates a PullPoint subscription of the camera and starts pulling events
+func NewEventSubscription(ctx context.Context, ptz *PTZControl) (*EventSubscription, error) {
+	if !ptz.connected {
+		return nil, ErrNotConnected
+	}
+
+	address, termination, err := createSubscription(ctx, ptz.cam)
+	if err != nil {
+		return nil, err
+	}
+
+	// the pulling outlives the request which created the subscription
+	pullCtx, cancel := context.WithCancel(context.Background())
+
+	sub := &EventSubscription{
+		dev: ptz.cam,
+		address: address,
+		termination: termination,
+		clients: make(map[chan Event]bool),
+		lock: new(sync.Mutex),
+		cancel: cancel,
+		done: make(chan struct{}),
+	}
+
+	go sub.run(pullCtx)
+
+	return sub, nil
+}
+
+// Subscribe returns a channel receiving all events until Unsubscribe
+func (sub *EventSubscription) Subscribe() chan Event {
+	client := make(chan Event, eventBuffer)
+
+	sub.lock.Lock()
+	sub.clients[client] = true
+	sub.lock.Unlock()
+
+	return client
+}
+
+func (sub *EventSubscription) Unsubscribe(client chan Event) {
+	sub.lock.Lock()
+	delete(sub.clients, client)
+	sub.lock.Unlock()
+}
+
+// Clients returns the number of subscribed clients
+func (sub *EventSubscription) Clients() int {
+	sub.lock.Lock()
+	defer sub.lock.Unlock()
+
+	return len(sub.clients)
+}
+
+// Close stops pulling and removes the subscription from the camera
+func (sub *EventSubscription) Close(ctx context.Context) error {
+	sub.cancel()
+	<-sub.done
+
+	return unsubscribeEvents(ctx, sub.dev, sub.address)
+}
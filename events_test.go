@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const soapEnvelope = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body>%s</env:Body></env:Envelope>`
+
+// Events service of a camera, the requests are kept as "action path"
+type eventCamera struct {
+	server *httptest.Server
+	// host of the subscription addresses, the server host if empty
+	host string
+	// pulls of these paths fail
+	failed map[string]bool
+	subscriptions int
+	requests []string
+	lock *sync.Mutex
+}
+
+func newEventCamera(t *testing.T) *eventCamera {
+	t.Helper()
+
+	camera := &eventCamera{failed: make(map[string]bool), lock: new(sync.Mutex)}
+	camera.server = httptest.NewServer(http.HandlerFunc(camera.serve))
+	t.Cleanup(camera.server.Close)
+
+	return camera
+}
+
+func (camera *eventCamera) serve(w http.ResponseWriter, r *http.Request) {
+	data, _ := io.ReadAll(r.Body)
+	body := string(data)
+
+	camera.lock.Lock()
+	defer camera.lock.Unlock()
+
+	var response string
+	switch {
+	case strings.Contains(body, "GetCapabilities"):
+		response = `<GetCapabilitiesResponse><Capabilities><Events><XAddr>http://` + r.Host + `/onvif/events</XAddr></Events></Capabilities></GetCapabilitiesResponse>`
+	case strings.Contains(body, "CreatePullPointSubscription"):
+		camera.subscriptions++
+		host := camera.host
+		if host == "" {
+			host = r.Host
+		}
+		camera.requests = append(camera.requests, "create " + r.URL.Path)
+		response = fmt.Sprintf(`<CreatePullPointSubscriptionResponse><SubscriptionReference><Address>http://%s/pullpoint/%d</Address></SubscriptionReference></CreatePullPointSubscriptionResponse>`, host, camera.subscriptions)
+	case strings.Contains(body, "PullMessages"):
+		camera.requests = append(camera.requests, "pull " + r.URL.Path)
+		if camera.failed[r.URL.Path] {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, soapEnvelope, `<Fault><Code><Value>Receiver</Value></Code><Reason><Text>gone</Text></Reason></Fault>`)
+			return
+		}
+		// long polling of a camera without events
+		camera.lock.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		camera.lock.Lock()
+		response = `<PullMessagesResponse></PullMessagesResponse>`
+	case strings.Contains(body, "Unsubscribe"):
+		camera.requests = append(camera.requests, "unsubscribe " + r.URL.Path)
+		response = `<UnsubscribeResponse></UnsubscribeResponse>`
+	case strings.Contains(body, "Renew"):
+		response = `<RenewResponse></RenewResponse>`
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	fmt.Fprintf(w, soapEnvelope, response)
+}
+
+func (camera *eventCamera) history() []string {
+	camera.lock.Lock()
+	defer camera.lock.Unlock()
+
+	return append([]string(nil), camera.requests...)
+}
+
+// Index of the first request, -1 if there is none
+func (camera *eventCamera) index(request string) int {
+	camera.lock.Lock()
+	defer camera.lock.Unlock()
+
+	for i, r := range camera.requests {
+		if r == request {
+			return i
+		}
+	}
+	return -1
+}
+
+// Control of the camera connected to its events service
+func (camera *eventCamera) control(t *testing.T) *PTZControl {
+	t.Helper()
+
+	host, port, _ := net.SplitHostPort(camera.server.Listener.Addr().String())
+	n, _ := strconv.Atoi(port)
+
+	dev, err := newOnvifDevice(context.Background(), PTZInfo{Ip: host, Port: uint16(n)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &PTZControl{cam: dev, connected: true, lock: new(sync.RWMutex)}
+}
+
+// Wait until the camera got the request
+func waitRequest(t *testing.T, camera *eventCamera, request string) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); camera.index(request) < 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("no %s, requests %v", request, camera.history())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEventResubscribe(t *testing.T) {
+	camera := newEventCamera(t)
+	camera.failed["/pullpoint/1"] = true
+
+	sub, err := NewEventSubscription(context.Background(), camera.control(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close(context.Background())
+
+	// the dropped subscription is removed before the next one is created
+	waitRequest(t, camera, "pull /pullpoint/2")
+	unsubscribed := camera.index("unsubscribe /pullpoint/1")
+	if unsubscribed < 0 || unsubscribed > camera.index("pull /pullpoint/2") {
+		t.Errorf("old subscription not removed, requests %v", camera.history())
+	}
+}
+
+func TestSubscriptionAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		ip string
+		want string
+	}{
+		{"http://192.168.1.2:8080/onvif/pullpoint/1", "192.168.1.2", "http://192.168.1.2:8080/onvif/pullpoint/1"},
+		// internal address behind NAT, the advertised port is kept
+		{"http://10.0.0.5:2020/event/sub?id=3", "203.0.113.7", "http://203.0.113.7:2020/event/sub?id=3"},
+		{"http://10.0.0.5/onvif/pullpoint", "203.0.113.7", "http://203.0.113.7/onvif/pullpoint"},
+		{"http://10.0.0.5/onvif/pullpoint", "fe80::1", "http://[fe80::1]/onvif/pullpoint"},
+		{"urn:uuid:1", "203.0.113.7", "urn:uuid:1"},
+	}
+
+	for _, test := range tests {
+		if address := subscriptionAddress(test.address, test.ip); address != test.want {
+			t.Errorf("%s: %s, want %s", test.address, address, test.want)
+		}
+	}
+}
+
+func TestEventSubscriptionHost(t *testing.T) {
+	camera := newEventCamera(t)
+	_, port, _ := net.SplitHostPort(camera.server.Listener.Addr().String())
+	camera.host = net.JoinHostPort("192.0.2.40", port)
+
+	sub, err := NewEventSubscription(context.Background(), camera.control(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close(context.Background())
+
+	if sub.address != camera.server.URL + "/pullpoint/1" {
+		t.Errorf("subscription address %s", sub.address)
+	}
+	waitRequest(t, camera, "pull /pullpoint/1")
+}
+
+func TestSessionEventsLastClient(t *testing.T) {
+	camera := newEventCamera(t)
+	session := newSession(camera.control(t))
+	defer session.Close(context.Background())
+
+	first, err := session.SubscribeEvents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := session.SubscribeEvents(context.Background())
+
+	session.UnsubscribeEvents(first)
+	waitRequest(t, camera, "pull /pullpoint/1")
+	if camera.index("unsubscribe /pullpoint/1") >= 0 {
+		t.Fatal("subscription removed with a client left")
+	}
+
+	// the last client stops the pulling
+	session.UnsubscribeEvents(second)
+	if camera.index("unsubscribe /pullpoint/1") < 0 || session.events != nil {
+		t.Fatalf("subscription kept without clients, requests %v", camera.history())
+	}
+	pulls := len(camera.history())
+	time.Sleep(100 * time.Millisecond)
+	if len(camera.history()) != pulls {
+		t.Errorf("camera pulled without clients, requests %v", camera.history())
+	}
+
+	// the next client subscribes again
+	client, err := session.SubscribeEvents(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitRequest(t, camera, "pull /pullpoint/2")
+	session.UnsubscribeEvents(client)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"time"
+)
+
+// Frame is a decoded video frame of a session, its image is not modified after publishing
+type Frame struct {
+	Image image.Image
+	// number of the frame in the session, starting with 1
+	Seq uint64
+	Time time.Time
+}
+
+// Internal
+
+// Copy img, the decoders reuse the buffer of their image for the next frame
+func cloneImage(img image.Image) *image.RGBA {
+	if src, ok := img.(*image.RGBA); ok {
+		dst := &image.RGBA{
+			Pix: make([]uint8, len(src.Pix)),
+			Stride: src.Stride,
+			Rect: src.Rect,
+		}
+		copy(dst.Pix, src.Pix)
+		return dst
+	}
+
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Rect, img, img.Bounds().Min, draw.Src)
+	return dst
+}
+
+// Resize the rect of img to width x height with nearest neighbour sampling
+func resizeImage(img image.Image, rect image.Rectangle, width int, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	size := rect.Size()
+
+	src, ok := img.(*image.RGBA)
+
+	for y := 0; y < height; y++ {
+		sy := rect.Min.Y + y * size.Y / height
+		for x := 0; x < width; x++ {
+			sx := rect.Min.X + x * size.X / width
+			if ok {
+				i := src.PixOffset(sx, sy)
+				copy(dst.Pix[dst.PixOffset(x, y):], src.Pix[i:i + 4])
+			} else {
+				dst.Set(x, y, img.At(sx, sy))
+			}
+		}
+	}
+
+	return dst
+}
+
+// Scale img by factor scale, an image of at least 1x1
+func scaleImage(img image.Image, scale float64) image.Image {
+	if scale == 1 {
+		return img
+	}
+
+	rect := img.Bounds()
+	width := int(float64(rect.Dx()) * scale)
+	height := int(float64(rect.Dy()) * scale)
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	return resizeImage(img, rect, width, height)
+}
+
+// Crop img to crop and resize it to width x height, a missing size keeps the aspect ratio.
+// An empty crop is the whole image
+func renderImage(img image.Image, crop image.Rectangle, width int, height int) image.Image {
+	if crop.Empty() {
+		crop = img.Bounds()
+	}
+
+	switch {
+	case width == 0 && height == 0:
+		width, height = crop.Dx(), crop.Dy()
+	case width == 0:
+		width = crop.Dx() * height / crop.Dy()
+	case height == 0:
+		height = crop.Dy() * width / crop.Dx()
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	if crop == img.Bounds() && width == crop.Dx() && height == crop.Dy() {
+		return img
+	}
+
+	return resizeImage(img, crop, width, height)
+}
+
+// Publish a decoded image as the next frame and wake up the waiting clients
+func (session *Session) setFrame(img image.Image) {
+	frame := &Frame{Image: cloneImage(img), Time: time.Now()}
+
+	session.lock.Lock()
+	if session.frame != nil {
+		frame.Seq = session.frame.Seq + 1
+	} else {
+		frame.Seq = 1
+	}
+	session.frame = frame
+	close(session.frame_ready)
+	session.frame_ready = make(chan struct{})
+	session.lock.Unlock()
+}
+
+// Interface for Outside
+
+// Frame returns the last frame, nil before the first frame
+func (session *Session) Frame() *Frame {
+	session.lock.RLock()
+	defer session.lock.RUnlock()
+
+	return session.frame
+}
+
+// NextFrame waits for a frame newer than the frame after, the last frame is returned at once if
+// it is newer. Clients which are slower than the stream skip the frames between
+func (session *Session) NextFrame(ctx context.Context, after uint64) (*Frame, error) {
+	for {
+		session.lock.RLock()
+		frame, ready := session.frame, session.frame_ready
+		session.lock.RUnlock()
+
+		if frame != nil && frame.Seq > after {
+			return frame, nil
+		}
+
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-session.Done():
+			return nil, fmt.Errorf("%w: session %s is closed", ErrNotFound, session.id)
+		}
+	}
+}
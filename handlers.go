@@ -0,0 +1,798 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frame rates of the MJPEG stream
+const (
+	mjpegDefaultFps = 10
+	mjpegMaxFps = 30
+)
+
+// Boundary between the frames of the MJPEG stream
+const mjpegBoundary = "frame"
+
+
+// Maximum width and height of a snapshot image
+const snapshotMaxSize = 8192
+
+// Fast PNG compression of snapshot images
+var pngEncoder = png.Encoder{CompressionLevel: png.BestSpeed}
+
+type SessionID struct {
+	Id string `json:"session_id"`
+}
+
+type Profile struct {
+	Name string `json:"profile"`
+}
+
+type Position struct {
+	Pan float64 `json:"Pan"`
+	Tilt float64 `json:"Tilt"`
+	Zoom float64 `json:"Zoom"`
+	PanSpeed float64 `json:"PanSpeed"`
+	TiltSpeed float64 `json:"TiltSpeed"`
+	ZoomSpeed float64 `json:"ZoomSpeed"`
+}
+
+type Velocity struct {
+	PanSpeed float64 `json:"PanSpeed"`
+	TiltSpeed float64 `json:"TiltSpeed"`
+	ZoomSpeed float64 `json:"ZoomSpeed"`
+	Timeout float64 `json:"Timeout"`
+}
+
+// Routes of the REST API of both servers
+var apiRoutes = []ApiRoute{
+	{Method: "GET", Path: "/ptz", Handle: apiHome},
+	{Method: "GET", Path: "/snapshot", Session: true, Handle: apiSnapshot},
+	{Method: "GET", Path: "/ptz/mjpeg", Session: true, Stream: apiMjpeg},
+	{Method: "GET", Path: "/ptz/snapshot", Session: true, Stream: apiSnapshotImage},
+	{Method: "GET", Path: "/ptz/stream", Session: true, Handle: apiStreamStatus},
+	{Method: "POST", Path: "/ptz/connect", Handle: apiConnect},
+	{Method: "POST", Path: "/ptz/disconnect", Session: true, Handle: apiDisconnect},
+	{Method: "GET", Path: "/ptz/discover", Handle: apiDiscover},
+	{Method: "GET", Path: "/ptz/cameras", Handle: apiCameras},
+	{Method: "GET", Path: "/ptz/cameras/:id", Handle: apiCamera},
+	{Method: "POST", Path: "/ptz/cameras/:id/connect", Handle: apiConnectCamera},
+	{Method: "GET", Path: "/ptz/cameras/:id/snapshot", Stream: apiCameraSnapshot},
+	{Method: "GET", Path: "/ptz/inventory/:bucket", Handle: apiInventory},
+	{Method: "GET", Path: "/ptz/inventory/:bucket/*key", Handle: apiInventoryItem},
+	// changes of the inventory need the session of a camera with valid credentials
+	{Method: "POST", Path: "/ptz/inventory/:bucket/*key", Session: true, Handle: apiPutInventoryItem},
+	{Method: "DELETE", Path: "/ptz/inventory/:bucket/*key", Session: true, Handle: apiRemoveInventoryItem},
+	{Method: "GET", Path: "/ptz/config", Session: true, Handle: apiConfig},
+	{Method: "GET", Path: "/ptz/device", Session: true, Handle: apiDevice},
+	{Method: "GET", Path: "/ptz/presets", Session: true, Handle: apiPresets},
+	{Method: "GET", Path: "/ptz/snapshot/uri", Session: true, Handle: apiSnapshotUri},
+	{Method: "GET", Path: "/ptz/position", Session: true, Handle: apiPosition},
+	{Method: "GET", Path: "/ptz/moving", Session: true, Handle: apiMoving},
+	{Method: "POST", Path: "/ptz/profile", Session: true, Handle: apiProfile},
+	{Method: "POST", Path: "/ptz/move/relative", Session: true, Handle: apiRelativeMove},
+	{Method: "POST", Path: "/ptz/move/continuous", Session: true, Handle: apiContinuousMove},
+	{Method: "POST", Path: "/ptz/goto/position", Session: true, Handle: apiGotoPosition},
+	{Method: "POST", Path: "/ptz/goto/preset", Session: true, Handle: apiGotoPreset},
+	{Method: "POST", Path: "/ptz/goto/home", Session: true, Handle: apiGotoHome},
+	{Method: "POST", Path: "/ptz/home/set", Session: true, Handle: apiSetHome},
+	{Method: "POST", Path: "/ptz/preset/set", Session: true, Handle: apiSetPreset},
+	{Method: "POST", Path: "/ptz/preset/remove", Session: true, Handle: apiRemovePreset},
+	{Method: "POST", Path: "/ptz/stop", Session: true, Handle: apiStop},
+	{Method: "POST", Path: "/ptz/aux", Session: true, Handle: apiAuxCommand},
+	{Method: "GET", Path: "/ptz/events", Session: true, Stream: apiEvents},
+	{Method: "GET", Path: "/ptz/tours", Session: true, Handle: apiTours},
+	{Method: "POST", Path: "/ptz/tour/save", Session: true, Handle: apiSaveTour},
+	{Method: "POST", Path: "/ptz/tour/remove", Session: true, Handle: apiRemoveTour},
+	{Method: "POST", Path: "/ptz/tour/start", Session: true, Handle: apiStartTour},
+	{Method: "POST", Path: "/ptz/tour/pause", Session: true, Handle: apiPauseTour},
+	{Method: "POST", Path: "/ptz/tour/resume", Session: true, Handle: apiResumeTour},
+	{Method: "POST", Path: "/ptz/tour/stop", Session: true, Handle: apiStopTour},
+	{Method: "GET", Path: "/ptz/presettours", Session: true, Handle: apiPresetTours},
+	{Method: "POST", Path: "/ptz/presettour/create", Session: true, Handle: apiCreatePresetTour},
+	{Method: "POST", Path: "/ptz/presettour/modify", Session: true, Handle: apiModifyPresetTour},
+	{Method: "POST", Path: "/ptz/presettour/operate", Session: true, Handle: apiOperatePresetTour},
+	{Method: "POST", Path: "/ptz/presettour/remove", Session: true, Handle: apiRemovePresetTour},
+	{Method: "GET", Path: "/ptz/imaging/settings", Session: true, Handle: apiImagingSettings},
+	{Method: "POST", Path: "/ptz/imaging/settings", Session: true, Handle: apiSetImagingSettings},
+	{Method: "POST", Path: "/ptz/imaging/move", Session: true, Handle: apiImagingMove},
+	{Method: "POST", Path: "/ptz/imaging/stop", Session: true, Handle: apiImagingStop},
+	{Method: "GET", Path: "/ptz/imaging/options", Session: true, Handle: apiImagingOptions},
+}
+
+func apiHome(req *ApiRequest) map[string]interface{} {
+	return map[string]interface{}{"message": "PTZ Server"}
+}
+
+func apiSnapshot(req *ApiRequest) map[string]interface{} {
+	return req.session.GetSnapshot(req.ctx)
+}
+
+// MJPEG stream of the session frames, "fps" limits the frame rate, "scale" resizes the
+// frames and "quality" is the JPEG quality. A slow client skips the frames it cannot receive
+func apiMjpeg(req *ApiRequest, w http.ResponseWriter) {
+	fps, err := req.queryFloat("fps", mjpegDefaultFps, 0.1, mjpegMaxFps)
+	if err != nil {
+		writeResponse(w, invalidRequest(err))
+		return
+	}
+	scale, err := req.queryFloat("scale", 1, 0.01, 1)
+	if err != nil {
+		writeResponse(w, invalidRequest(err))
+		return
+	}
+	quality, err := req.queryFloat("quality", 80, 1, 100)
+	if err != nil {
+		writeResponse(w, invalidRequest(err))
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	session := req.session
+	interval := time.Duration(float64(time.Second) / fps)
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=" + mjpegBoundary)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var seq uint64
+	var buf bytes.Buffer
+	var last time.Time
+
+	for {
+		// a frame received while sleeping is sent after the interval
+		if wait := interval - time.Since(last); wait > 0 {
+			select {
+			case <-req.ctx.Done():
+				return
+			case <-session.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		frame, err := session.NextFrame(req.ctx, seq)
+		if err != nil {
+			return
+		}
+		seq, last = frame.Seq, time.Now()
+
+		buf.Reset()
+		if err := jpeg.Encode(&buf, scaleImage(frame.Image, scale), &jpeg.Options{Quality: int(quality)}); err != nil {
+			logWarn("MJPEG encode error:", err)
+			return
+		}
+
+		// the write blocks until a slow client has received the frame, the frames meanwhile are skipped
+		fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, buf.Len())
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return
+		}
+		fmt.Fprint(w, "\r\n")
+		flusher.Flush()
+
+		session.ActivateSession()
+	}
+}
+
+// Parse the crop rectangle "x,y,w,h" of the snapshot, it must be inside bounds
+func parseCrop(value string, bounds image.Rectangle) (image.Rectangle, error) {
+	if value == "" {
+		return image.Rectangle{}, nil
+	}
+
+	parts := strings.Split(value, ",")
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		number, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("crop must be x,y,w,h")
+		}
+		numbers[i] = number
+	}
+	if len(numbers) != 4 || numbers[2] <= 0 || numbers[3] <= 0 {
+		return image.Rectangle{}, fmt.Errorf("crop must be x,y,w,h")
+	}
+
+	crop := image.Rect(numbers[0], numbers[1], numbers[0] + numbers[2], numbers[1] + numbers[3]).Add(bounds.Min)
+	if !crop.In(bounds) {
+		return image.Rectangle{}, fmt.Errorf("crop must be inside the %dx%d frame", bounds.Dx(), bounds.Dy())
+	}
+
+	return crop, nil
+}
+
+// Options of the snapshot images
+type snapshotQuery struct {
+	width int
+	height int
+	quality int
+	// quality is given, a camera JPEG is encoded again
+	encode bool
+	crop string
+	fresh bool
+	source string
+}
+
+func parseSnapshotQuery(req *ApiRequest) (snapshotQuery, error) {
+	query := snapshotQuery{crop: req.query.Get("crop"), source: req.query.Get("source"), encode: req.query.Get("quality") != ""}
+
+	width, err := req.queryFloat("width", 0, 0, snapshotMaxSize)
+	if err != nil {
+		return query, err
+	}
+	height, err := req.queryFloat("height", 0, 0, snapshotMaxSize)
+	if err != nil {
+		return query, err
+	}
+	quality, err := req.queryFloat("quality", 80, 1, 100)
+	if err != nil {
+		return query, err
+	}
+	query.width, query.height, query.quality = int(width), int(height), int(quality)
+
+	if value := req.query.Get("fresh"); value != "" {
+		if query.fresh, err = strconv.ParseBool(value); err != nil {
+			return query, fmt.Errorf("fresh must be a boolean")
+		}
+	}
+
+	switch query.source {
+	case "":
+		query.source = SnapshotAuto
+	case SnapshotAuto, SnapshotStream, SnapshotCamera:
+	default:
+		return query, fmt.Errorf("source must be %s, %s or %s", SnapshotAuto, SnapshotStream, SnapshotCamera)
+	}
+
+	return query, nil
+}
+
+// Write the snapshot as JPEG, or PNG if the client accepts image/png and not image/jpeg. The
+// image of the camera is sent as is if it has the format, is not resized and no quality is given
+func writeSnapshot(req *ApiRequest, w http.ResponseWriter, query snapshotQuery, snapshot *Snapshot, name string) {
+	accept := req.header.Get("Accept")
+	asPng := strings.Contains(accept, "image/png") && !strings.Contains(accept, "image/jpeg")
+	contentType := "image/jpeg"
+	if asPng {
+		contentType = "image/png"
+	}
+
+	// the query is part of the resource, the image and the format make the ETag
+	etag := fmt.Sprintf("\"%s-%s-%s\"", name, snapshot.Tag(), strings.TrimPrefix(contentType, "image/"))
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Frame-Timestamp", snapshot.Time.UTC().Format(time.RFC3339Nano))
+	if snapshot.Seq > 0 {
+		w.Header().Set("X-Frame-Seq", strconv.FormatUint(snapshot.Seq, 10))
+		w.Header().Set("X-Snapshot-Source", SnapshotStream)
+	} else {
+		w.Header().Set("X-Snapshot-Source", SnapshotCamera)
+	}
+
+	if req.header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data := snapshot.Data
+	resized := query.crop != "" || query.width > 0 || query.height > 0
+
+	if data == nil || resized || query.encode || snapshot.ContentType != contentType {
+		img, err := snapshot.Decode()
+		if err != nil {
+			writeResponse(w, apiResponse(http.StatusBadGateway, "Cannot decode snapshot: " + err.Error(), nil))
+			return
+		}
+
+		crop, err := parseCrop(query.crop, img.Bounds())
+		if err != nil {
+			writeResponse(w, invalidRequest(err))
+			return
+		}
+
+		img = renderImage(img, crop, query.width, query.height)
+
+		var buf bytes.Buffer
+		if asPng {
+			err = pngEncoder.Encode(&buf, img)
+		} else {
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: query.quality})
+		}
+		if err != nil {
+			writeResponse(w, apiResponse(http.StatusInternalServerError, "Cannot encode snapshot: " + err.Error(), nil))
+			return
+		}
+		data = buf.Bytes()
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// Snapshot image of the session, see writeSnapshot. "width" and "height" resize the image
+// after "crop" (x,y,w,h), "quality" is the JPEG quality and "fresh" waits for the next frame
+// instead of returning the last one. "source" takes the snapshot from the stream, the
+// camera (GetSnapshotUri) or by default from the camera if the stream is not playing or has no frame
+func apiSnapshotImage(req *ApiRequest, w http.ResponseWriter) {
+	query, err := parseSnapshotQuery(req)
+	if err != nil {
+		writeResponse(w, invalidRequest(err))
+		return
+	}
+
+	session := req.session
+	snapshot, err := SessionSnapshot(req.ctx, session, query.source, query.fresh)
+	if err != nil {
+		writeResponse(w, apiResponse(errorCode(err), "Cannot get snapshot", nil))
+		return
+	}
+
+	writeSnapshot(req, w, query, snapshot, session.id)
+}
+
+// Snapshot image of a camera of the inventory taken by the camera, without session
+func apiCameraSnapshot(req *ApiRequest, w http.ResponseWriter) {
+	query, err := parseSnapshotQuery(req)
+	if err != nil {
+		writeResponse(w, invalidRequest(err))
+		return
+	}
+
+	ptz, err := gCameras.Connect(req.ctx, req.Param("id"))
+	if err != nil {
+		writeResponse(w, errorResponse(err))
+		return
+	}
+
+	snapshot, err := CameraSnapshot(req.ctx, ptz)
+	if err != nil {
+		writeResponse(w, apiResponse(errorCode(err), "Cannot get snapshot", nil))
+		return
+	}
+
+	writeSnapshot(req, w, query, snapshot, req.Param("id"))
+}
+
+func apiSnapshotUri(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.ptz.GetSnapshotUri(req.ctx)
+	return res
+}
+
+func apiStreamStatus(req *ApiRequest) map[string]interface{} {
+	return req.session.StreamStatus()
+}
+
+// Start a session with the posted camera address and credentials
+func apiConnect(req *ApiRequest) map[string]interface{} {
+	var info PTZInfo
+
+	if err := req.decode(&info); err != nil {
+		return invalidRequest(err)
+	}
+
+	return startSession(req, info.Ip, info.Port, func() (*Session, error) {
+		return NewSession(req.ctx, info.Ip, info.Port, info.Username, info.Password)
+	})
+}
+
+// Remove the client from its session, the session is closed with its last client
+func apiDisconnect(req *ApiRequest) map[string]interface{} {
+	if err := gSessions.Release(req.session.id); err != nil {
+		return errorResponse(err)
+	}
+
+	req.cookie = "-"
+
+	return apiResponse(http.StatusOK, "Session ended", nil)
+}
+
+func apiDiscover(req *ApiRequest) map[string]interface{} {
+	timeout, err := req.queryFloat("timeout", discoveryTimeout.Seconds(), 0.1, discoveryMaxTimeout.Seconds())
+	if err != nil {
+		return invalidRequest(err)
+	}
+
+	devices, err := Discover(req.ctx, "", time.Duration(timeout * float64(time.Second)))
+
+	res := apiResponse(http.StatusOK, "Discovered devices", map[string]interface{}{"Devices": devices})
+
+	if err != nil {
+		res["code"] = errorCode(err)
+		res["message"] = err.Error()
+	}
+
+	return res
+}
+
+func apiCameras(req *ApiRequest) map[string]interface{} {
+	res, _ := gCameras.GetCameras()
+	return res
+}
+
+func apiCamera(req *ApiRequest) map[string]interface{} {
+	res, _ := gCameras.GetCamera(req.Param("id"))
+	return res
+}
+
+// Start a session of a camera of the inventory
+func apiConnectCamera(req *ApiRequest) map[string]interface{} {
+	ptz, err := gCameras.Connect(req.ctx, req.Param("id"))
+
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	return startSession(req, ptz.info.Ip, ptz.info.Port, func() (*Session, error) {
+		return NewCameraSession(req.ctx, ptz)
+	})
+}
+
+func apiInventory(req *ApiRequest) map[string]interface{} {
+	res, _ := GetInventory(req.Param("bucket"))
+	return res
+}
+
+// Keys of presets and tours are owner/name
+func apiInventoryItem(req *ApiRequest) map[string]interface{} {
+	res, _ := GetInventoryItem(req.Param("bucket"), req.Param("key"))
+	return res
+}
+
+func apiPutInventoryItem(req *ApiRequest) map[string]interface{} {
+	var data json.RawMessage
+
+	if err := req.decode(&data); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := PutInventoryItem(req.Param("bucket"), req.Param("key"), data)
+	return res
+}
+
+func apiRemoveInventoryItem(req *ApiRequest) map[string]interface{} {
+	res, _ := RemoveInventoryItem(req.Param("bucket"), req.Param("key"))
+	return res
+}
+
+func apiConfig(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.ptz.GetConfigs()
+	return res
+}
+
+func apiDevice(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.ptz.GetDevice(req.ctx)
+	return res
+}
+
+func apiPresets(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.ptz.GetPresets(req.ctx)
+	return res
+}
+
+func apiPosition(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.ptz.GetPosition(req.ctx)
+	return res
+}
+
+func apiMoving(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.ptz.IsMoving(req.ctx)
+	return res
+}
+
+// Change the profile of the video stream, the current profile is not changed again
+func apiProfile(req *ApiRequest) map[string]interface{} {
+	var profile Profile
+
+	if err := req.decode(&profile); err != nil {
+		return invalidRequest(err)
+	}
+
+	if profile.Name != req.session.ptz.Profile() {
+		// the old stream must stop in time, also if the client waits longer
+		ctx, cancel := context.WithTimeout(req.ctx, sessionCloseTimeout)
+		defer cancel()
+
+		if err := req.session.ChangeProfile(ctx, profile.Name); err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	return apiResponse(http.StatusOK, "Change profile", profile)
+}
+
+func apiRelativeMove(req *ApiRequest) map[string]interface{} {
+	var pos Position
+
+	if err := req.decode(&pos); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.ptz.MoveRelativePosition(req.ctx, pos.Pan, pos.Tilt, pos.Zoom, pos.PanSpeed, pos.TiltSpeed, pos.ZoomSpeed)
+	return res
+}
+
+func apiContinuousMove(req *ApiRequest) map[string]interface{} {
+	var vel Velocity
+
+	if err := req.decode(&vel); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.ptz.ContinuousMove(req.ctx, vel.PanSpeed, vel.TiltSpeed, vel.ZoomSpeed, vel.Timeout)
+	return res
+}
+
+func apiGotoPosition(req *ApiRequest) map[string]interface{} {
+	var pos Position
+
+	if err := req.decode(&pos); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.ptz.GotoPosition(req.ctx, pos.Pan, pos.Tilt, pos.Zoom, pos.PanSpeed, pos.TiltSpeed, pos.ZoomSpeed)
+	return res
+}
+
+func apiGotoPreset(req *ApiRequest) map[string]interface{} {
+	var preset PTZPresetID
+
+	if err := req.decode(&preset); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.ptz.GotoPreset(req.ctx, preset.Id)
+	return res
+}
+
+func apiGotoHome(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.ptz.GotoHome(req.ctx)
+	return res
+}
+
+func apiSetHome(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.ptz.SetHomePosition(req.ctx)
+	return res
+}
+
+func apiSetPreset(req *ApiRequest) map[string]interface{} {
+	var preset PTZPresetName
+
+	if err := req.decode(&preset); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.ptz.SetPreset(req.ctx, preset.Id, preset.Name)
+	return res
+}
+
+func apiRemovePreset(req *ApiRequest) map[string]interface{} {
+	var preset PTZPresetID
+
+	if err := req.decode(&preset); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.ptz.RemovePreset(req.ctx, preset.Id)
+	return res
+}
+
+func apiStop(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.ptz.Stop(req.ctx)
+	return res
+}
+
+func apiAuxCommand(req *ApiRequest) map[string]interface{} {
+	var aux PTZAuxCommand
+
+	if err := req.decode(&aux); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.ptz.SendAuxiliaryCommand(req.ctx, aux.Command)
+	return res
+}
+
+// Server-Sent Events of the camera, an "onvif" event with JSON Event data for each notification
+func apiEvents(req *ApiRequest, w http.ResponseWriter) {
+	session := req.session
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the camera is pulled while the session has clients
+	client, err := session.SubscribeEvents(req.ctx)
+	if err != nil {
+		writeResponse(w, apiResponse(errorCode(err), "Cannot subscribe to events", nil))
+		return
+	}
+	defer session.UnsubscribeEvents(client)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-req.ctx.Done():
+			return
+		case <-session.Done():
+			return
+		case event := <-client:
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "event: onvif\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-keepAlive.C:
+			session.ActivateSession()
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func apiTours(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.tour.GetTours()
+	return res
+}
+
+func apiSaveTour(req *ApiRequest) map[string]interface{} {
+	var tour Tour
+
+	if err := req.decode(&tour); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.tour.SaveTour(tour)
+	return res
+}
+
+func apiRemoveTour(req *ApiRequest) map[string]interface{} {
+	var tour TourName
+
+	if err := req.decode(&tour); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.tour.RemoveTour(tour.Name)
+	return res
+}
+
+func apiStartTour(req *ApiRequest) map[string]interface{} {
+	var tour TourName
+
+	if err := req.decode(&tour); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.tour.Start(tour.Name)
+	return res
+}
+
+func apiPauseTour(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.tour.Pause()
+	return res
+}
+
+func apiResumeTour(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.tour.Resume()
+	return res
+}
+
+func apiStopTour(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.tour.Stop()
+	return res
+}
+
+func apiPresetTours(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.ptz.GetPresetTours(req.ctx)
+	return res
+}
+
+func apiCreatePresetTour(req *ApiRequest) map[string]interface{} {
+	var tour PresetTour
+
+	if err := req.decode(&tour); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.ptz.CreatePresetTour(req.ctx, tour)
+	return res
+}
+
+func apiModifyPresetTour(req *ApiRequest) map[string]interface{} {
+	var tour PresetTour
+
+	if err := req.decode(&tour); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.ptz.ModifyPresetTour(req.ctx, tour)
+	return res
+}
+
+func apiOperatePresetTour(req *ApiRequest) map[string]interface{} {
+	var operation PresetTourOperation
+
+	if err := req.decode(&operation); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.ptz.OperatePresetTour(req.ctx, operation.Token, operation.Operation)
+	return res
+}
+
+func apiRemovePresetTour(req *ApiRequest) map[string]interface{} {
+	var tour PresetTourToken
+
+	if err := req.decode(&tour); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.ptz.RemovePresetTour(req.ctx, tour.Token)
+	return res
+}
+
+func apiImagingSettings(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.imaging.GetSettings(req.ctx)
+	return res
+}
+
+func apiSetImagingSettings(req *ApiRequest) map[string]interface{} {
+	var settings ImagingSettings
+
+	if err := req.decode(&settings); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.imaging.SetSettings(req.ctx, settings)
+	return res
+}
+
+func apiImagingMove(req *ApiRequest) map[string]interface{} {
+	var move FocusMoveParams
+
+	if err := req.decode(&move); err != nil {
+		return invalidRequest(err)
+	}
+
+	res, _ := req.session.imaging.Move(req.ctx, move.Mode, move.Value, move.Speed)
+	return res
+}
+
+func apiImagingStop(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.imaging.Stop(req.ctx)
+	return res
+}
+
+func apiImagingOptions(req *ApiRequest) map[string]interface{} {
+	res, _ := req.session.imaging.GetMoveOptions(req.ctx)
+	return res
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// ImagingControl controls focus, exposure and IR cut filter of the video source
+// of the current PTZControl profile with the ONVIF Imaging service
+type ImagingControl struct {
+	ptz *PTZControl
+}
+
+type ImagingExposure struct {
+	Mode string `xml:"onvif:Mode"`
+	MinExposureTime *float64 `xml:"onvif:MinExposureTime,omitempty"`
+	MaxExposureTime *float64 `xml:"onvif:MaxExposureTime,omitempty"`
+	MinGain *float64 `xml:"onvif:MinGain,omitempty"`
+	MaxGain *float64 `xml:"onvif:MaxGain,omitempty"`
+	MinIris *float64 `xml:"onvif:MinIris,omitempty"`
+	MaxIris *float64 `xml:"onvif:MaxIris,omitempty"`
+	ExposureTime *float64 `xml:"onvif:ExposureTime,omitempty"`
+	Gain *float64 `xml:"onvif:Gain,omitempty"`
+	Iris *float64 `xml:"onvif:Iris,omitempty"`
+}
+
+type ImagingFocus struct {
+	AutoFocusMode string `xml:"onvif:AutoFocusMode"`
+	DefaultSpeed *float64 `xml:"onvif:DefaultSpeed,omitempty"`
+	NearLimit *float64 `xml:"onvif:NearLimit,omitempty"`
+	FarLimit *float64 `xml:"onvif:FarLimit,omitempty"`
+}
+
+// ImagingSettings are the imaging settings of a video source, missing (nil or empty) settings are not changed
+type ImagingSettings struct {
+	Brightness *float64 `xml:"onvif:Brightness,omitempty"`
+	ColorSaturation *float64 `xml:"onvif:ColorSaturation,omitempty"`
+	Contrast *float64 `xml:"onvif:Contrast,omitempty"`
+	Exposure *ImagingExposure `xml:"onvif:Exposure,omitempty"`
+	Focus *ImagingFocus `xml:"onvif:Focus,omitempty"`
+	// ON, OFF or AUTO
+	IrCutFilter string `xml:"onvif:IrCutFilter,omitempty"`
+	Sharpness *float64 `xml:"onvif:Sharpness,omitempty"`
+}
+
+type FocusMoveParams struct {
+	// absolute, relative or continuous
+	Mode string `json:"Mode"`
+	// position of absolute, distance of relative, unused by continuous
+	Value float64 `json:"Value"`
+	// speed of the move, 0 means default speed except continuous
+	Speed float64 `json:"Speed"`
+}
+
+type FocusOptions struct {
+	Absolute bool
+	Position PTZRange
+	AbsoluteSpeed PTZRange
+	Relative bool
+	Distance PTZRange
+	RelativeSpeed PTZRange
+	Continuous bool
+	ContinuousSpeed PTZRange
+}
+
+// Requests of the SDK have no omitempty, so all settings would be overwritten
+
+type setImagingSettings struct {
+	XMLName string `xml:"timg:SetImagingSettings"`
+	VideoSourceToken string `xml:"timg:VideoSourceToken"`
+	ImagingSettings ImagingSettings `xml:"timg:ImagingSettings"`
+	ForcePersistence bool `xml:"timg:ForcePersistence"`
+}
+
+type getImagingSettings struct {
+	XMLName string `xml:"timg:GetImagingSettings"`
+	VideoSourceToken string `xml:"timg:VideoSourceToken"`
+}
+
+type focusAbsolute struct {
+	Position float64 `xml:"onvif:Position"`
+	Speed *float64 `xml:"onvif:Speed,omitempty"`
+}
+
+type focusRelative struct {
+	Distance float64 `xml:"onvif:Distance"`
+	Speed *float64 `xml:"onvif:Speed,omitempty"`
+}
+
+type focusContinuous struct {
+	Speed float64 `xml:"onvif:Speed"`
+}
+
+type focusMove struct {
+	Absolute *focusAbsolute `xml:"onvif:Absolute,omitempty"`
+	Relative *focusRelative `xml:"onvif:Relative,omitempty"`
+	Continuous *focusContinuous `xml:"onvif:Continuous,omitempty"`
+}
+
+type imagingMove struct {
+	XMLName string `xml:"timg:Move"`
+	VideoSourceToken string `xml:"timg:VideoSourceToken"`
+	Focus focusMove `xml:"timg:Focus"`
+}
+
+type imagingStop struct {
+	XMLName string `xml:"timg:Stop"`
+	VideoSourceToken string `xml:"timg:VideoSourceToken"`
+}
+
+type getMoveOptions struct {
+	XMLName string `xml:"timg:GetMoveOptions"`
+	VideoSourceToken string `xml:"timg:VideoSourceToken"`
+}
+
+// Internal
+
+func getImagingSettingsOf(ctx context.Context, dev *onvifDevice, source string) (ImagingSettings, error) {
+	var settings ImagingSettings
+
+	doc, err := dev.callAt(ctx, "imaging", getImagingSettings{VideoSourceToken: source})
+
+	if err != nil {
+		return settings, err
+	}
+
+	r := fieldReader{}
+	node := r.element(doc.Root(), "/Envelope/Body/GetImagingSettingsResponse/ImagingSettings")
+
+	if r.err != nil {
+		return settings, r.err
+	}
+
+	settings.Brightness = r.optionalFloat64(node, "Brightness")
+	settings.ColorSaturation = r.optionalFloat64(node, "ColorSaturation")
+	settings.Contrast = r.optionalFloat64(node, "Contrast")
+	settings.Sharpness = r.optionalFloat64(node, "Sharpness")
+
+	if ir := node.SelectElement("IrCutFilter"); ir != nil {
+		settings.IrCutFilter = ir.Text()
+	}
+
+	if exposure := node.SelectElement("Exposure"); exposure != nil {
+		settings.Exposure = &ImagingExposure{
+			Mode: r.text(exposure, "Mode"),
+			MinExposureTime: r.optionalFloat64(exposure, "MinExposureTime"),
+			MaxExposureTime: r.optionalFloat64(exposure, "MaxExposureTime"),
+			MinGain: r.optionalFloat64(exposure, "MinGain"),
+			MaxGain: r.optionalFloat64(exposure, "MaxGain"),
+			MinIris: r.optionalFloat64(exposure, "MinIris"),
+			MaxIris: r.optionalFloat64(exposure, "MaxIris"),
+			ExposureTime: r.optionalFloat64(exposure, "ExposureTime"),
+			Gain: r.optionalFloat64(exposure, "Gain"),
+			Iris: r.optionalFloat64(exposure, "Iris"),
+		}
+	}
+
+	if focus := node.SelectElement("Focus"); focus != nil {
+		settings.Focus = &ImagingFocus{
+			AutoFocusMode: r.text(focus, "AutoFocusMode"),
+			DefaultSpeed: r.optionalFloat64(focus, "DefaultSpeed"),
+			NearLimit: r.optionalFloat64(focus, "NearLimit"),
+			FarLimit: r.optionalFloat64(focus, "FarLimit"),
+		}
+	}
+
+	return settings, r.err
+}
+
+func setImagingSettingsOf(ctx context.Context, dev *onvifDevice, source string, settings ImagingSettings) (error) {
+	setSettings := setImagingSettings{VideoSourceToken: source, ImagingSettings: settings, ForcePersistence: true}
+	doc, err := dev.callAt(ctx, "imaging", setSettings)
+
+	return checkResponse(doc, err, "SetImagingSettingsResponse")
+}
+
+func focusMoveOf(ctx context.Context, dev *onvifDevice, source string, mode string, value float64, speed float64) (error) {
+	var move focusMove
+
+	var optionalSpeed *float64
+	if speed != 0 {
+		optionalSpeed = &speed
+	}
+
+	switch mode {
+	case "absolute":
+		move.Absolute = &focusAbsolute{Position: value, Speed: optionalSpeed}
+	case "relative":
+		move.Relative = &focusRelative{Distance: value, Speed: optionalSpeed}
+	case "continuous":
+		move.Continuous = &focusContinuous{Speed: speed}
+	default:
+		return fmt.Errorf("%w: focus move mode %q", ErrInvalidArgument, mode)
+	}
+
+	doc, err := dev.callAt(ctx, "imaging", imagingMove{VideoSourceToken: source, Focus: move})
+
+	return checkResponse(doc, err, "MoveResponse")
+}
+
+func focusStopOf(ctx context.Context, dev *onvifDevice, source string) (error) {
+	doc, err := dev.callAt(ctx, "imaging", imagingStop{VideoSourceToken: source})
+
+	return checkResponse(doc, err, "StopResponse")
+}
+
+func getMoveOptionsOf(ctx context.Context, dev *onvifDevice, source string) (FocusOptions, error) {
+	var options FocusOptions
+
+	doc, err := dev.callAt(ctx, "imaging", getMoveOptions{VideoSourceToken: source})
+
+	if err != nil {
+		return options, err
+	}
+
+	r := fieldReader{}
+	node := r.element(doc.Root(), "/Envelope/Body/GetMoveOptionsResponse/MoveOptions")
+
+	if r.err != nil {
+		return options, r.err
+	}
+
+	// every kind of move is optional
+	if absolute := node.SelectElement("Absolute"); absolute != nil {
+		options.Absolute = true
+		options.Position = parseRange(absolute.SelectElement("Position"))
+		options.AbsoluteSpeed = parseRange(absolute.SelectElement("Speed"))
+	}
+	if relative := node.SelectElement("Relative"); relative != nil {
+		options.Relative = true
+		options.Distance = parseRange(relative.SelectElement("Distance"))
+		options.RelativeSpeed = parseRange(relative.SelectElement("Speed"))
+	}
+	if continuous := node.SelectElement("Continuous"); continuous != nil {
+		options.Continuous = true
+		options.ContinuousSpeed = parseRange(continuous.SelectElement("Speed"))
+	}
+
+	return options, nil
+}
+
+// Interface for Outside
+
+func NewImagingControl(ptz *PTZControl) *ImagingControl {
+	return &ImagingControl{ptz: ptz}
+}
+
+// video source of the current profile
+func (img *ImagingControl) source() (string, error) {
+	if !img.ptz.connected {
+		return "", ErrNotConnected
+	}
+
+	token := img.ptz.token()
+	for _, stream := range img.ptz.configs.Streams {
+		if stream.Token == token && stream.Source != "" {
+			return stream.Source, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: no video source in profile %s", ErrUnsupported, img.ptz.Profile())
+}
+
+func (img *ImagingControl) GetSettings(ctx context.Context) (map[string]interface{}, error) {
+	source, err := img.source()
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get imaging settings", "data": nil}, err
+	}
+
+	settings, err := getImagingSettingsOf(ctx, img.ptz.cam, source)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get imaging settings", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Imaging settings", "data": settings}, nil
+}
+
+func (img *ImagingControl) SetSettings(ctx context.Context, settings ImagingSettings) (map[string]interface{}, error) {
+	source, err := img.source()
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot set imaging settings", "data": nil}, err
+	}
+
+	err = setImagingSettingsOf(ctx, img.ptz.cam, source, settings)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot set imaging settings", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Set imaging settings", "data": nil}, nil
+}
+
+// Move moves the focus, mode is absolute (value is position), relative (value is distance)
+// or continuous (speed only, until Stop)
+func (img *ImagingControl) Move(ctx context.Context, mode string, value float64, speed float64) (map[string]interface{}, error) {
+	source, err := img.source()
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot move focus", "data": nil}, err
+	}
+
+	err = focusMoveOf(ctx, img.ptz.cam, source, mode, value, speed)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot move focus", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Move focus", "data": nil}, nil
+}
+
+func (img *ImagingControl) Stop(ctx context.Context) (map[string]interface{}, error) {
+	source, err := img.source()
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot stop focus", "data": nil}, err
+	}
+
+	err = focusStopOf(ctx, img.ptz.cam, source)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot stop focus", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Stop focus", "data": nil}, nil
+}
+
+func (img *ImagingControl) GetMoveOptions(ctx context.Context) (map[string]interface{}, error) {
+	source, err := img.source()
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get focus move options", "data": nil}, err
+	}
+
+	options, err := getMoveOptionsOf(ctx, img.ptz.cam, source)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get focus move options", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Focus move options", "data": options}, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// StoredPreset is a position saved on the server, stored by owner/name like tours
+type StoredPreset struct {
+	Name string `json:"name"`
+	Pan float64 `json:"Pan"`
+	Tilt float64 `json:"Tilt"`
+	Zoom float64 `json:"Zoom"`
+}
+
+// Buckets of the inventory REST interface, meta is internal
+var inventoryBuckets = map[string]bool{bucketCameras: true, bucketPresets: true, bucketTours: true, bucketSettings: true}
+
+// Internal
+
+func checkBucket(bucket string) error {
+	if !inventoryBuckets[bucket] {
+		return fmt.Errorf("%w: inventory %s", ErrNotFound, bucket)
+	}
+	return nil
+}
+
+// Check the value of a key, presets and tours are stored as owner/name
+func validateItem(bucket string, key string, data json.RawMessage) (interface{}, error) {
+	name := key
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		name = key[i+1:]
+	}
+
+	switch bucket {
+	case bucketPresets:
+		var preset StoredPreset
+		if err := json.Unmarshal(data, &preset); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+		}
+		if !strings.Contains(key, "/") || preset.Name != name {
+			return nil, fmt.Errorf("%w: preset key must be owner/%s", ErrInvalidArgument, preset.Name)
+		}
+		return preset, nil
+	case bucketTours:
+		var tour Tour
+		if err := json.Unmarshal(data, &tour); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+		}
+		if err := validateTour(tour); err != nil {
+			return nil, err
+		}
+		if !strings.Contains(key, "/") || tour.Name != name {
+			return nil, fmt.Errorf("%w: tour key must be owner/%s", ErrInvalidArgument, tour.Name)
+		}
+		return tour, nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+	}
+
+	return value, nil
+}
+
+// Interface for Outside, cameras are handled by the camera registry
+
+func GetInventory(bucket string) (map[string]interface{}, error) {
+	if err := checkBucket(bucket); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Inventory not found", "data": nil}, err
+	}
+
+	if bucket == bucketCameras {
+		return gCameras.GetCameras()
+	}
+
+	values, err := gStore.List(bucket)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get inventory", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Inventory", "data": values}, nil
+}
+
+func GetInventoryItem(bucket string, key string) (map[string]interface{}, error) {
+	if err := checkBucket(bucket); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Inventory not found", "data": nil}, err
+	}
+
+	if bucket == bucketCameras {
+		return gCameras.GetCamera(key)
+	}
+
+	value, err := gStore.Get(bucket, key)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get inventory item", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Inventory item", "data": value}, nil
+}
+
+func PutInventoryItem(bucket string, key string, data json.RawMessage) (map[string]interface{}, error) {
+	if err := checkBucket(bucket); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Inventory not found", "data": nil}, err
+	}
+
+	if bucket == bucketCameras {
+		var record cameraRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			err = fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+			return map[string]interface{}{"code": errorCode(err), "message": err.Error(), "data": nil}, err
+		}
+		if record.Id == "" {
+			record.Id = key
+		}
+		if record.Id != key {
+			err := fmt.Errorf("%w: camera id %s is not %s", ErrInvalidArgument, record.Id, key)
+			return map[string]interface{}{"code": errorCode(err), "message": err.Error(), "data": nil}, err
+		}
+		return gCameras.PutCamera(record.config())
+	}
+
+	value, err := validateItem(bucket, key, data)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": err.Error(), "data": nil}, err
+	}
+
+	if err := gStore.Put(bucket, key, value); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot save inventory item", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Save inventory item", "data": value}, nil
+}
+
+func RemoveInventoryItem(bucket string, key string) (map[string]interface{}, error) {
+	if err := checkBucket(bucket); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Inventory not found", "data": nil}, err
+	}
+
+	if bucket == bucketCameras {
+		return gCameras.RemoveCamera(key)
+	}
+
+	if err := gStore.Delete(bucket, key); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot remove inventory item", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Remove inventory item", "data": nil}, nil
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"strconv"
 	"time"
 )
 
@@ -16,12 +19,12 @@ func printJson(obj interface{}) {
 	fmt.Println(string(json))
 }
 
-func waitForStop(ptz *PTZControl, timeout int) {
+func waitForStop(ctx context.Context, ptz *PTZControl, timeout int) {
 	fmt.Println("Wait for stop")
 	i := 0
 	for i = 0; i <= timeout; i++ {
 		time.Sleep(500 * time.Millisecond)
-		res, _ := ptz.IsMoving()
+		res, _ := ptz.IsMoving(ctx)
 		status := res["data"]
 		printJson(status)
 		if !status.(Moving).Moving {
@@ -37,13 +40,14 @@ func waitForStop(ptz *PTZControl, timeout int) {
 }
 
 func test() {
+	ctx := context.Background()
 	info, err := LoadConfig()
 	if err != nil {
 		fmt.Println(err.Error())
 		return
 	}
 
-	ptz, err := NewPTZControl(info.Ip, info.Port, info.Username, info.Password)
+	ptz, err := NewPTZControl(ctx, info.Ip, info.Port, info.Username, info.Password)
 	
 	if err != nil {
 		fmt.Println("Failed to connect to  IP camera.")
@@ -52,75 +56,141 @@ func test() {
 
 	printJson(ptz.configs)
 	printJson(ptz.profiles)
-	fmt.Println("Current Profile: " + ptz.profile_name)
+	fmt.Println("Current Profile: " + ptz.Profile())
 
 	for key := range ptz.profiles {
 		ptz.SetProfile(key)
-		fmt.Println("Set Profile: " + ptz.profile_name)
-		res, _ := ptz.GetStreamUri()
+		fmt.Println("Set Profile: " + ptz.Profile())
+		res, _ := ptz.GetStreamUri(ctx)
 		printJson(res["data"])
 	}
 
-	res, _ := ptz.IsMoving()
+	res, _ := ptz.IsMoving(ctx)
 	printJson(res["data"])
-	res, _ = ptz.GetPosition()
+	res, _ = ptz.GetPosition(ctx)
 	printJson(res["data"])
 
 	res, _ = ptz.GetConfigs()
 	printJson(res["data"])
 
-	res, _ = ptz.GetPresets()
+	res, _ = ptz.GetPresets(ctx)
 	printJson(res["data"])
 
 	fmt.Println("Goto Preset 1")
-	res, _ = ptz.GotoPreset("1")
+	res, _ = ptz.GotoPreset(ctx, "1")
 	printJson(res["data"])
 
-	waitForStop(ptz, 10)
+	waitForStop(ctx, ptz, 10)
 	time.Sleep(2 * time.Second)
 
-	_, _ = ptz.GotoPosition(0.8, 0.8, 0, 0.5, 0.5, 0.5)
+	_, _ = ptz.GotoPosition(ctx, 0.8, 0.8, 0, 0.5, 0.5, 0.5)
 
 	time.Sleep(1 * time.Second)
 	fmt.Println("Send Stop")
-	res, _ = ptz.Stop()
+	res, _ = ptz.Stop(ctx)
 	printJson(res)
 
 
-	waitForStop(ptz, 10)
+	waitForStop(ctx, ptz, 10)
 	time.Sleep(2 * time.Second)
 
 	fmt.Println("Goto Home")
-	res, _ = ptz.GotoHome()
+	res, _ = ptz.GotoHome(ctx)
 	printJson(res)
 
-	waitForStop(ptz, 10)
+	waitForStop(ctx, ptz, 10)
 	time.Sleep(2 * time.Second)
 
 	fmt.Println("move to 0.2, -0.2, 0.2")
-	res, _ = ptz.GotoPosition(0.2, -0.2, 0, 1, 1, 1)
+	res, _ = ptz.GotoPosition(ctx, 0.2, -0.2, 0, 1, 1, 1)
 	printJson(res)
 
-	waitForStop(ptz, 10)
+	waitForStop(ctx, ptz, 10)
 	time.Sleep(2 * time.Second)
 
 	fmt.Println("relative move -0.1, 0.1")
-	res, _ = ptz.MoveRelativePosition(-0.1, 0.1, 0, 1, 1, 1)
+	res, _ = ptz.MoveRelativePosition(ctx, -0.1, 0.1, 0, 1, 1, 1)
 	printJson(res)
 
-	waitForStop(ptz, 10)
+	waitForStop(ctx, ptz, 10)
 	time.Sleep(2 * time.Second)
 
 	fmt.Println("Goto Preset 2")
-	res, _ = ptz.GotoPreset("2")
+	res, _ = ptz.GotoPreset(ctx, "2")
 	printJson(res["data"])
 
-	waitForStop(ptz, 10)
+	waitForStop(ctx, ptz, 10)
 }
 
 
+// print ONVIF devices on the local network
+func discover(timeout time.Duration) {
+	fmt.Println("Discovering ONVIF devices...")
+
+	devices, err := Discover(context.Background(), "", timeout)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+
+	for _, device := range devices {
+		fmt.Printf("%s:%d\t%s\t%s\n", device.Ip, device.Port, device.Name, device.Hardware)
+	}
+
+	fmt.Printf("%d device(s) found\n", len(devices))
+}
+
 func main() {
+	// ./ptz_go discover [timeout seconds]
+	if len(os.Args) > 1 && os.Args[1] == "discover" {
+		timeout := 0.0
+		if len(os.Args) > 2 {
+			timeout, _ = strconv.ParseFloat(os.Args[2], 64)
+		}
+		discover(time.Duration(timeout * float64(time.Second)))
+		return
+	}
+
+	// ./ptz_go [-config config.yaml] [-listen :8000] ...
+	flags := NewServerFlags()
+	if err := flags.Parse(os.Args[1:]); err != nil {
+		return
+	}
+
+	config, err := LoadConfigFile(flags.Config)
+	if err != nil {
+		fmt.Println("No camera loaded from " + flags.Config)
+	}
+
+	gServer, err = LoadServerConfig(config.Server, flags)
+	if err != nil {
+		fmt.Println("Invalid server config:", err)
+		return
+	}
+
+	store, err := OpenStore(config.Store)
+	if err != nil {
+		fmt.Println("Cannot open inventory, it is kept in memory only:", err)
+		store = NewMemoryStore()
+	}
+	defer store.Close()
+	gStore = store
+
+	// passwords are not moved to a memory only secrets
+	secrets, err := OpenSecrets(config.Secrets)
+	if err != nil {
+		fmt.Println("Cannot open secrets:", err)
+		return
+	}
+	gSecrets = secrets
+
+	gCameras, err = LoadCameraRegistry(config, store, secrets)
+	if err != nil {
+		fmt.Println("Cannot migrate inventory:", err)
+	}
+
 	// test()
 	// server_main()
 	server_gin_main()
+
+	gSessions.CloseAll()
 }
\ No newline at end of file
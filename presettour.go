@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+	"github.com/use-go/onvif/xsd/onvif"
+)
+
+// Operations of OperatePresetTour
+var presetTourOperations = []string{"Start", "Stop", "Pause", "Extended"}
+
+// PresetTourSpot is a preset of a tour run by the camera, StayTime in seconds
+type PresetTourSpot struct {
+	Preset string `json:"preset"`
+	Home bool `json:"Home"`
+	// 0 ~ 1.0, 0 means the default speed
+	Speed float64 `json:"Speed"`
+	StayTime float64 `json:"StayTime"`
+}
+
+// PresetTour is a tour stored and run by the camera, State is Idle, Touring, Paused or Extended
+type PresetTour struct {
+	Token string `json:"token"`
+	Name string `json:"name"`
+	State string `json:"state"`
+	AutoStart bool `json:"AutoStart"`
+	// number of rounds, 0 means not set
+	RecurringTime int `json:"RecurringTime"`
+	// seconds, 0 means not set
+	RecurringDuration float64 `json:"RecurringDuration"`
+	// Forward, Backward or empty
+	Direction string `json:"Direction"`
+	RandomPresetOrder bool `json:"RandomPresetOrder"`
+	Spots []PresetTourSpot `json:"spots"`
+}
+
+type PresetTourToken struct {
+	Token string `json:"token"`
+}
+
+type PresetTourOperation struct {
+	Token string `json:"token"`
+	Operation string `json:"operation"`
+}
+
+// PresetTour of the SDK has a single TourSpot and no omitempty, OperatePresetTour has wrong prefixes
+
+type presetTourDetail struct {
+	PresetToken string `xml:"onvif:PresetToken,omitempty"`
+	Home bool `xml:"onvif:Home,omitempty"`
+}
+
+type presetTourSpot struct {
+	PresetDetail presetTourDetail `xml:"onvif:PresetDetail"`
+	Speed *onvif.PTZSpeed `xml:"onvif:Speed,omitempty"`
+	StayTime string `xml:"onvif:StayTime,omitempty"`
+}
+
+type presetTourStartingCondition struct {
+	RandomPresetOrder bool `xml:"RandomPresetOrder,attr,omitempty"`
+	RecurringTime int `xml:"onvif:RecurringTime,omitempty"`
+	RecurringDuration string `xml:"onvif:RecurringDuration,omitempty"`
+	Direction string `xml:"onvif:Direction,omitempty"`
+}
+
+type presetTourStatus struct {
+	State string `xml:"onvif:State"`
+}
+
+type presetTourRequest struct {
+	Token string `xml:"token,attr"`
+	Name string `xml:"onvif:Name,omitempty"`
+	Status presetTourStatus `xml:"onvif:Status"`
+	AutoStart bool `xml:"onvif:AutoStart"`
+	StartingCondition presetTourStartingCondition `xml:"onvif:StartingCondition"`
+	TourSpot []presetTourSpot `xml:"onvif:TourSpot"`
+}
+
+type getPresetTours struct {
+	XMLName string `xml:"tptz:GetPresetTours"`
+	ProfileToken string `xml:"tptz:ProfileToken"`
+}
+
+type createPresetTour struct {
+	XMLName string `xml:"tptz:CreatePresetTour"`
+	ProfileToken string `xml:"tptz:ProfileToken"`
+}
+
+type modifyPresetTour struct {
+	XMLName string `xml:"tptz:ModifyPresetTour"`
+	ProfileToken string `xml:"tptz:ProfileToken"`
+	PresetTour presetTourRequest `xml:"tptz:PresetTour"`
+}
+
+type operatePresetTour struct {
+	XMLName string `xml:"tptz:OperatePresetTour"`
+	ProfileToken string `xml:"tptz:ProfileToken"`
+	PresetTourToken string `xml:"tptz:PresetTourToken"`
+	Operation string `xml:"tptz:Operation"`
+}
+
+type removePresetTour struct {
+	XMLName string `xml:"tptz:RemovePresetTour"`
+	ProfileToken string `xml:"tptz:ProfileToken"`
+	PresetTourToken string `xml:"tptz:PresetTourToken"`
+}
+
+// Internal
+
+func validatePresetTour(tour PresetTour) error {
+	switch tour.Direction {
+	case "", "Forward", "Backward", "Extended":
+	default:
+		return fmt.Errorf("%w: tour direction %q", ErrInvalidArgument, tour.Direction)
+	}
+
+	if tour.RecurringTime < 0 || tour.RecurringDuration < 0 {
+		return fmt.Errorf("%w: tour recurring time or duration", ErrOutOfRange)
+	}
+
+	for i, spot := range tour.Spots {
+		if spot.Preset == "" && !spot.Home {
+			return fmt.Errorf("%w: spot %d has no preset", ErrInvalidArgument, i)
+		}
+		if spot.Speed < 0 || spot.Speed > 1 {
+			return fmt.Errorf("%w: speed of spot %d", ErrOutOfRange, i)
+		}
+		if spot.StayTime < 0 {
+			return fmt.Errorf("%w: stay time of spot %d", ErrOutOfRange, i)
+		}
+	}
+
+	return nil
+}
+
+func newPresetTourRequest(tour PresetTour) presetTourRequest {
+	state := tour.State
+	if state == "" {
+		state = "Idle"
+	}
+
+	request := presetTourRequest{
+		Token: tour.Token,
+		Name: tour.Name,
+		Status: presetTourStatus{State: state},
+		AutoStart: tour.AutoStart,
+		StartingCondition: presetTourStartingCondition{
+			RandomPresetOrder: tour.RandomPresetOrder,
+			RecurringTime: tour.RecurringTime,
+			Direction: tour.Direction,
+		},
+		TourSpot: make([]presetTourSpot, 0, len(tour.Spots)),
+	}
+
+	if tour.RecurringDuration > 0 {
+		request.StartingCondition.RecurringDuration = formatDuration(tour.RecurringDuration)
+	}
+
+	for _, spot := range tour.Spots {
+		s := presetTourSpot{PresetDetail: presetTourDetail{PresetToken: spot.Preset, Home: spot.Home}}
+		if spot.Home {
+			s.PresetDetail.PresetToken = ""
+		}
+		if spot.Speed > 0 {
+			s.Speed = &onvif.PTZSpeed{PanTilt: onvif.Vector2D{X: spot.Speed, Y: spot.Speed}, Zoom: onvif.Vector1D{X: spot.Speed}}
+		}
+		if spot.StayTime > 0 {
+			s.StayTime = formatDuration(spot.StayTime)
+		}
+		request.TourSpot = append(request.TourSpot, s)
+	}
+
+	return request
+}
+
+// Lenient parsing, only the token is required
+func parsePresetTour(r *fieldReader, node *etree.Element) PresetTour {
+	tour := PresetTour{Token: r.attr(node, "token"), Spots: make([]PresetTourSpot, 0)}
+
+	if name := node.SelectElement("Name"); name != nil {
+		tour.Name = name.Text()
+	}
+	if state := node.FindElement("Status/State"); state != nil {
+		tour.State = state.Text()
+	}
+	if auto := node.SelectElement("AutoStart"); auto != nil {
+		tour.AutoStart = auto.Text() == "true"
+	}
+
+	if condition := node.SelectElement("StartingCondition"); condition != nil {
+		tour.RandomPresetOrder = condition.SelectAttrValue("RandomPresetOrder", "") == "true"
+		if recurring := condition.SelectElement("RecurringTime"); recurring != nil {
+			if i, err := strconv.Atoi(strings.TrimSpace(recurring.Text())); err == nil {
+				tour.RecurringTime = i
+			}
+		}
+		if duration := condition.SelectElement("RecurringDuration"); duration != nil {
+			tour.RecurringDuration = float64(parseDuration(strings.TrimSpace(duration.Text())))
+		}
+		if direction := condition.SelectElement("Direction"); direction != nil {
+			tour.Direction = direction.Text()
+		}
+	}
+
+	for _, node := range node.SelectElements("TourSpot") {
+		var spot PresetTourSpot
+
+		if preset := node.FindElement("PresetDetail/PresetToken"); preset != nil {
+			spot.Preset = preset.Text()
+		}
+		if home := node.FindElement("PresetDetail/Home"); home != nil {
+			spot.Home = home.Text() == "true"
+		}
+		if speed := node.FindElement("Speed/PanTilt"); speed != nil {
+			if f, err := strconv.ParseFloat(speed.SelectAttrValue("x", ""), 64); err == nil {
+				spot.Speed = f
+			}
+		}
+		if stay := node.SelectElement("StayTime"); stay != nil {
+			spot.StayTime = float64(parseDuration(strings.TrimSpace(stay.Text())))
+		}
+
+		tour.Spots = append(tour.Spots, spot)
+	}
+
+	return tour
+}
+
+func getPresetToursOf(ctx context.Context, dev *onvifDevice, token string) ([]PresetTour, error) {
+	doc, err := dev.callAt(ctx, "ptz", getPresetTours{ProfileToken: token})
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := fieldReader{}
+	r.element(doc.Root(), "/Envelope/Body/GetPresetToursResponse")
+
+	tours := make([]PresetTour, 0)
+	for _, node := range doc.Root().FindElements("/Envelope/Body/GetPresetToursResponse/PresetTour") {
+		tours = append(tours, parsePresetTour(&r, node))
+	}
+
+	return tours, r.err
+}
+
+func createPresetTourOf(ctx context.Context, dev *onvifDevice, token string) (string, error) {
+	doc, err := dev.callAt(ctx, "ptz", createPresetTour{ProfileToken: token})
+
+	if err != nil {
+		return "", err
+	}
+
+	r := fieldReader{}
+	id := r.text(doc.Root(), "/Envelope/Body/CreatePresetTourResponse/PresetTourToken")
+
+	return id, r.err
+}
+
+func modifyPresetTourOf(ctx context.Context, dev *onvifDevice, token string, tour PresetTour) (error) {
+	modify := modifyPresetTour{ProfileToken: token, PresetTour: newPresetTourRequest(tour)}
+	doc, err := dev.callAt(ctx, "ptz", modify)
+
+	return checkResponse(doc, err, "ModifyPresetTourResponse")
+}
+
+func operatePresetTourOf(ctx context.Context, dev *onvifDevice, token string, id string, operation string) (error) {
+	operate := operatePresetTour{ProfileToken: token, PresetTourToken: id, Operation: operation}
+	doc, err := dev.callAt(ctx, "ptz", operate)
+
+	return checkResponse(doc, err, "OperatePresetTourResponse")
+}
+
+func removePresetTourOf(ctx context.Context, dev *onvifDevice, token string, id string) (error) {
+	doc, err := dev.callAt(ctx, "ptz", removePresetTour{ProfileToken: token, PresetTourToken: id})
+
+	return checkResponse(doc, err, "RemovePresetTourResponse")
+}
+
+// Interface for Outside
+
+func (ptz *PTZControl) GetPresetTours(ctx context.Context) (map[string]interface{}, error) {
+	if !ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	tours, err := getPresetToursOf(ctx, ptz.cam, ptz.token())
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get preset tours", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Preset tours", "data": tours}, nil
+}
+
+// CreatePresetTour creates a tour on the camera and sets its spots, the token of the tour is returned
+func (ptz *PTZControl) CreatePresetTour(ctx context.Context, tour PresetTour) (map[string]interface{}, error) {
+	if !ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	if err := validatePresetTour(tour); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": err.Error(), "data": nil}, err
+	}
+
+	token := ptz.token()
+
+	id, err := createPresetTourOf(ctx, ptz.cam, token)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot create preset tour", "data": nil}, err
+	}
+
+	tour.Token = id
+	err = modifyPresetTourOf(ctx, ptz.cam, token, tour)
+
+	if err != nil {
+		// do not leave an empty tour on the camera
+		removePresetTourOf(ctx, ptz.cam, token, id)
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot set preset tour", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Create preset tour", "data": PresetTourToken{Token: id}}, nil
+}
+
+func (ptz *PTZControl) ModifyPresetTour(ctx context.Context, tour PresetTour) (map[string]interface{}, error) {
+	if !ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	if tour.Token == "" {
+		err := fmt.Errorf("%w: preset tour token is required", ErrInvalidArgument)
+		return map[string]interface{}{"code": errorCode(err), "message": "Preset tour token is required", "data": nil}, err
+	}
+
+	if err := validatePresetTour(tour); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": err.Error(), "data": nil}, err
+	}
+
+	err := modifyPresetTourOf(ctx, ptz.cam, ptz.token(), tour)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot modify preset tour", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Modify preset tour", "data": PresetTourToken{Token: tour.Token}}, nil
+}
+
+// OperatePresetTour starts, stops or pauses a tour on the camera
+func (ptz *PTZControl) OperatePresetTour(ctx context.Context, id string, operation string) (map[string]interface{}, error) {
+	if !ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	valid := false
+	for _, op := range presetTourOperations {
+		if op == operation {
+			valid = true
+			break
+		}
+	}
+
+	if !valid {
+		err := fmt.Errorf("%w: preset tour operation %q", ErrInvalidArgument, operation)
+		return map[string]interface{}{"code": errorCode(err), "message": "Invalid preset tour operation", "data": nil}, err
+	}
+
+	// the camera moves on its own, a running server tour yields
+	if operation == "Start" {
+		ptz.moved()
+	}
+
+	err := operatePresetTourOf(ctx, ptz.cam, ptz.token(), id, operation)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot operate preset tour", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Operate preset tour", "data": PresetTourOperation{Token: id, Operation: operation}}, nil
+}
+
+func (ptz *PTZControl) RemovePresetTour(ctx context.Context, id string) (map[string]interface{}, error) {
+	if !ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	err := removePresetTourOf(ctx, ptz.cam, ptz.token(), id)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot remove preset tour", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Remove preset tour", "data": PresetTourToken{Token: id}}, nil
+}
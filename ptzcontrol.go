@@ -4,24 +4,37 @@ import (
 	"fmt"
 	"io"
 	"errors"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"reflect"
 	"context"
+	"encoding/xml"
 	goonvif "github.com/use-go/onvif"
+	"github.com/use-go/onvif/device"
+	"github.com/use-go/onvif/gosoap"
 	"github.com/use-go/onvif/media"
 	"github.com/use-go/onvif/ptz"
+	"github.com/use-go/onvif/xsd"
 	"github.com/use-go/onvif/xsd/onvif"
 	"github.com/beevik/etree"
 )
 
 type PTZControl struct{
-	ctx context.Context
-	cam *goonvif.Device
+	cam *onvifDevice
 	connected bool
 	info PTZInfo
 	configs PTZConfigs
+	device PTZDevice
 	profiles map[string]string
 	profile_name string
+	// called on each move requested through the interface, e.g. to pause a tour
+	on_move func()
+	// profile_name, device and on_move lock, the control is shared by sessions and tours
+	lock *sync.RWMutex
 }
 
 type PTZInfo struct {
@@ -40,6 +53,8 @@ type PTZInfo struct {
 type Stream struct {
 	Token string
 	Name string
+	// video source token, used by imaging
+	Source string
 	Video struct {
 		Encoding string
 		Resolution struct {
@@ -65,11 +80,42 @@ type PTZRange struct {
 	Max float32
 }
 
+type PTZSpace struct {
+	Uri string
+	X PTZRange
+	Y PTZRange
+}
+
+type PTZSpaces struct {
+	AbsolutePanTilt []PTZSpace
+	AbsoluteZoom []PTZSpace
+	RelativePanTilt []PTZSpace
+	RelativeZoom []PTZSpace
+	ContinuousPanTilt []PTZSpace
+	ContinuousZoom []PTZSpace
+	PanTiltSpeed []PTZSpace
+	ZoomSpeed []PTZSpace
+}
+
 type PTZConfig struct {
 	Pan PTZRange
 	Tilt PTZRange
 	Zoom PTZRange
-	// Speed PTZRange
+	PanTiltSpeed PTZRange
+	ZoomSpeed PTZRange
+	// continuous move timeout in seconds
+	Timeout PTZRange
+	Absolute bool
+	Relative bool
+	Continuous bool
+	HomeSupported bool
+	FixedHomePosition bool
+	MaxPresets int
+	// 0 if the camera has no preset tours
+	MaxPresetTours int
+	PresetTourOperations []string
+	AuxiliaryCommands []string
+	Spaces PTZSpaces
 }
 
 type PTZConfigs struct {
@@ -104,48 +150,384 @@ type PTZPresetID struct {
 	Id string `json:"preset"`
 }
 
+type PTZPresetName struct {
+	Id string `json:"preset"`
+	Name string `json:"name"`
+}
+
+// Auxiliary command advertised by the PTZ node, e.g. tt:Wiper|On, and the answer of the camera
+type PTZAuxCommand struct {
+	Command string `json:"command"`
+	Response string `json:"response"`
+}
+
 type Moving struct {
 	Moving bool  `json:"Moving"`
 	PanTilt string  `json:"PanTilt"`
 	Zoom string  `json:"Zoom"`
 }
 
+// Timeout of an ONVIF request, the request is also cancelled with its context
+const callTimeout = 10 * time.Second
+
+type DeviceInfo struct {
+	Manufacturer string
+	Model string
+	FirmwareVersion string
+	SerialNumber string
+	HardwareId string
+}
+
+type DeviceService struct {
+	Namespace string
+	XAddr string
+	Version string
+}
+
+type DeviceTime struct {
+	DateTimeType string
+	DaylightSavings bool
+	TimeZone string
+	UTC time.Time
+	// camera clock minus local clock in seconds, WS-Security fails on a large offset
+	Offset float64
+}
+
+type PTZDevice struct {
+	Info DeviceInfo
+	// service name and address from GetCapabilities
+	Capabilities map[string]string
+	Services []DeviceService
+	Time DeviceTime
+}
+
+// Default timeout (seconds) of a continuous move, so a lost Stop does not leave the camera moving
+const defaultContinuousTimeout = 10
+
 // Internal
 
  func not_connected() map[string]interface{} {
-	return map[string]interface{}{"code": 404, "message": "Cannot connect to IP Camera", "data": nil}
+	return map[string]interface{}{"code": errorCode(ErrNotConnected), "message": "Cannot connect to IP Camera", "data": nil}
  }
 
-func readResponse(resp *http.Response) string {
+// onvifDevice is the SDK device with the credentials, requests are sent with a context
+// and bounded by callTimeout
+type onvifDevice struct {
+	*goonvif.Device
+	info PTZInfo
+	client *http.Client
+	// the last request got no answer, see unreachable
+	lost bool
+	state *sync.Mutex
+}
+
+func newOnvifDevice(ctx context.Context, info PTZInfo) (*onvifDevice, error) {
+	client := &http.Client{Timeout: callTimeout}
+
+	type result struct {
+		dev *goonvif.Device
+		err error
+	}
+
+	// goonvif.NewDevice has no context, it is bounded by the client timeout
+	done := make(chan result, 1)
+	go func() {
+		dev, err := goonvif.NewDevice(goonvif.DeviceParams{Xaddr: fmt.Sprintf("%s:%d", info.Ip, info.Port), Username: info.Username, Password: info.Password, HttpClient: client})
+		done <- result{dev: dev, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-done:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return &onvifDevice{Device: res.dev, info: info, client: client, state: new(sync.Mutex)}, nil
+	}
+}
+
+// Send method to the service of its SDK package (ptz, media, ...)
+func (dev *onvifDevice) call(ctx context.Context, method interface{}) (*etree.Document, error) {
+	pkgPath := strings.Split(reflect.TypeOf(method).PkgPath(), "/")
+	service := strings.ToLower(pkgPath[len(pkgPath)-1])
+
+	return dev.callAt(ctx, service, method)
+}
+
+// Send method to the service endpoint, also for requests which are not defined or wrongly defined in the SDK
+func (dev *onvifDevice) callAt(ctx context.Context, service string, method interface{}) (*etree.Document, error) {
+	endpoint := dev.GetEndpoint(service)
+	if endpoint == "" {
+		// vendors name services differently, e.g. events and event
+		for name, url := range dev.GetServices() {
+			if strings.Contains(name, service) {
+				endpoint = url
+				break
+			}
+		}
+	}
+	if endpoint == "" {
+		return nil, fmt.Errorf("%w: %s service", ErrUnsupported, service)
+	}
+
+	return dev.post(ctx, endpoint, method)
+}
+
+// Send method to a WS-Addressing endpoint reference, e.g. a PullPoint subscription
+func (dev *onvifDevice) callAddress(ctx context.Context, address string, method interface{}) (*etree.Document, error) {
+	to := etree.NewElement("wsa:To")
+	to.SetText(address)
+
+	return dev.post(ctx, address, method, to)
+}
+
+func (dev *onvifDevice) post(ctx context.Context, endpoint string, method interface{}, headers ...*etree.Element) (*etree.Document, error) {
+	output, err := xml.Marshal(method)
+	if err != nil {
+		return nil, err
+	}
+
+	body := etree.NewDocument()
+	if err := body.ReadFromBytes(output); err != nil {
+		return nil, err
+	}
+
+	soap := gosoap.NewEmptySOAP()
+	soap.AddBodyContent(body.Root())
+	soap.AddRootNamespaces(goonvif.Xlmns)
+	soap.AddAction()
+
+	if dev.info.Username != "" && dev.info.Password != "" {
+		soap.AddWSSecurity(dev.info.Username, dev.info.Password)
+	}
+
+	for _, header := range headers {
+		soap.AddHeaderContent(header)
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, callTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(callCtx, http.MethodPost, endpoint, strings.NewReader(soap.String()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/soap+xml; charset=utf-8")
+
+	doc, err := readResponse(dev.client.Do(req))
+	// a camera which answers, also with a fault, is reachable
+	dev.setLost(unreachable(ctx, err))
+
+	return doc, err
+}
+
+// The camera did not answer err in time or the connection failed, a request canceled by ctx
+// says nothing about the camera
+func unreachable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr)
+}
+
+func (dev *onvifDevice) setLost(lost bool) {
+	dev.state.Lock()
+	defer dev.state.Unlock()
+
+	dev.lost = lost
+}
+
+func (dev *onvifDevice) isLost() bool {
+	dev.state.Lock()
+	defer dev.state.Unlock()
+
+	return dev.lost
+}
+
+// Read the SOAP response, SOAP Fault and HTTP error are returned as error
+func readResponse(resp *http.Response, err error) (*etree.Document, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	if resp == nil {
+		return nil, errors.New("empty response")
+	}
+
+	defer resp.Body.Close()
+
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	// fmt.Println(string(b))
+	doc := etree.NewDocument()
+
+	if err := doc.ReadFromBytes(b); err != nil || doc.Root() == nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		if err == nil {
+			err = errors.New("empty response")
+		}
+		return nil, err
+	}
+
+	if fault := doc.Root().FindElement("/Envelope/Body/Fault"); fault != nil {
+		return nil, parseFault(fault)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	return doc, nil
+}
+
+// Parse SOAP 1.2 or SOAP 1.1 Fault
+func parseFault(fault *etree.Element) *SOAPFault {
+	var soapFault SOAPFault
+
+	if code := fault.SelectElement("Code"); code != nil {
+		if value := code.SelectElement("Value"); value != nil {
+			soapFault.Code = value.Text()
+		}
+		for sub := code.SelectElement("Subcode"); sub != nil; sub = sub.SelectElement("Subcode") {
+			if value := sub.SelectElement("Value"); value != nil {
+				soapFault.Subcodes = append(soapFault.Subcodes, value.Text())
+			}
+		}
+	} else if code := fault.SelectElement("faultcode"); code != nil {
+		soapFault.Code = code.Text()
+	}
+
+	if reason := fault.FindElement("Reason/Text"); reason != nil {
+		soapFault.Reason = reason.Text()
+	} else if reason := fault.SelectElement("faultstring"); reason != nil {
+		soapFault.Reason = reason.Text()
+	}
+
+	return &soapFault
+}
+
+// fieldReader reads required fields of a response, the first error is kept and later reads return zero values
+type fieldReader struct {
+	err error
+}
+
+func (r *fieldReader) element(node *etree.Element, path string) *etree.Element {
+	if r.err != nil {
+		return nil
+	}
+
+	e := node.FindElement(path)
+	if e == nil {
+		r.err = &MissingFieldError{Path: node.GetPath() + "/" + path}
+	}
+
+	return e
+}
+
+func (r *fieldReader) text(node *etree.Element, path string) string {
+	e := r.element(node, path)
+	if e == nil {
+		return ""
 	}
-	return string(b)
+
+	return e.Text()
 }
 
-func str2uint32(s string) uint32 {
-	i, err := strconv.Atoi(s)
+func (r *fieldReader) attr(node *etree.Element, name string) string {
+	if r.err != nil || node == nil {
+		return ""
+	}
+
+	a := node.SelectAttr(name)
+	if a == nil {
+		r.err = &MissingFieldError{Path: node.GetPath() + "@" + name}
+		return ""
+	}
+
+	return a.Value
+}
+
+func (r *fieldReader) uint32Value(node *etree.Element, path string) uint32 {
+	s := r.text(node, path)
+	if r.err != nil {
+		return 0
+	}
+
+	i, err := strconv.ParseUint(strings.TrimSpace(s), 10, 32)
 	if err != nil {
-		panic(err)
+		r.err = &InvalidFieldError{Path: node.GetPath() + "/" + path, Value: s, Err: err}
+		return 0
 	}
+
 	return uint32(i)
 }
 
-func str2float32(s string) float32 {
-	f, err := strconv.ParseFloat(s, 32)
+func (r *fieldReader) float64Value(node *etree.Element, path string) float64 {
+	s := r.text(node, path)
+	if r.err != nil {
+		return 0
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		r.err = &InvalidFieldError{Path: node.GetPath() + "/" + path, Value: s, Err: err}
+		return 0
+	}
+
+	return f
+}
+
+// optional field, nil if missing
+func (r *fieldReader) optionalFloat64(node *etree.Element, path string) *float64 {
+	if r.err != nil || node.FindElement(path) == nil {
+		return nil
+	}
+
+	f := r.float64Value(node, path)
+	if r.err != nil {
+		return nil
+	}
+
+	return &f
+}
+
+func (r *fieldReader) float32Value(node *etree.Element, path string) float32 {
+	return float32(r.float64Value(node, path))
+}
+
+func (r *fieldReader) attrFloat32(node *etree.Element, name string) float32 {
+	s := r.attr(node, name)
+	if r.err != nil {
+		return 0
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 32)
 	if err != nil {
-		panic(err)
+		r.err = &InvalidFieldError{Path: node.GetPath() + "@" + name, Value: s, Err: err}
+		return 0
 	}
+
 	return float32(f)
 }
 
-func str2float64(s string) float64 {
-	f, err := strconv.ParseFloat(s, 64)
+// Check the response element of a command without output
+func checkResponse(doc *etree.Document, err error, name string) error {
 	if err != nil {
-		panic(err)
+		return err
 	}
-	return f
+
+	r := fieldReader{}
+	r.element(doc.Root(), "/Envelope/Body/" + name)
+
+	return r.err
 }
 
 func emptyConfig() PTZConfigs {
@@ -155,7 +537,7 @@ func emptyConfig() PTZConfigs {
  
  func emptyPTZConfig() PTZConfig {
 	empty := PTZRange{Min: 0, Max: 0}
-	ptz := PTZConfig{Pan: empty, Tilt: empty, Zoom: empty/*, Speed: empty*/}
+	ptz := PTZConfig{Pan: empty, Tilt: empty, Zoom: empty, PanTiltSpeed: empty, ZoomSpeed: empty, Timeout: empty}
 	return ptz
 }
 
@@ -164,105 +546,329 @@ func emptyConfig() PTZConfigs {
 	 return status
 }
  
-func getMediaProfiles(dev *goonvif.Device) ([]Stream, map[string]string, string, error) {
+func getMediaProfiles(ctx context.Context, dev *onvifDevice) ([]Stream, map[string]string, string, error) {
 	getProfiles := media.GetProfiles{}
-	getProfilesResponseXML, err := dev.CallMethod(getProfiles)
+	doc, err := dev.call(ctx, getProfiles)
 	
-	xml := readResponse(getProfilesResponseXML)
-	// fmt.Println(xml)
-	doc := etree.NewDocument()
 	streams := make([]Stream, 0)
 	profiles := make(map[string]string, 0)
 	name := ""
-	if err := doc.ReadFromString(xml); err == nil {
-		nodes := doc.Root().FindElements("/Envelope/Body/GetProfilesResponse/Profiles")
 
-		for _, node := range nodes {
-			var stream Stream
-			stream.Token = node.SelectAttr("token").Value
-			stream.Name = node.SelectElement("Name").Text()
-			name = stream.Name
-			profiles[stream.Name] = stream.Token
-			stream.Video.Encoding = node.FindElement("VideoEncoderConfiguration/Encoding").Text()
-			stream.Video.Quality = str2float64(node.FindElement("VideoEncoderConfiguration/Quality").Text())
-			stream.Video.Resolution.Width = str2uint32(node.FindElement("VideoEncoderConfiguration/Resolution/Width").Text())
-			stream.Video.Resolution.Height = str2uint32(node.FindElement("VideoEncoderConfiguration/Resolution/Height").Text())
-			stream.Video.RateControl.FrameRateLimit = str2uint32(node.FindElement("VideoEncoderConfiguration/RateControl/FrameRateLimit").Text())
-			stream.Video.RateControl.EncodingInterval = str2uint32(node.FindElement("VideoEncoderConfiguration/RateControl/EncodingInterval").Text())
-			stream.Video.RateControl.BitrateLimit = str2uint32(node.FindElement("VideoEncoderConfiguration/RateControl/BitrateLimit").Text())
-			stream.Audio.Bitrate = str2uint32(node.FindElement("AudioEncoderConfiguration/Bitrate").Text())
-			stream.Audio.Encoding = node.FindElement("AudioEncoderConfiguration/Encoding").Text()
-			stream.Audio.SampleRate = str2uint32(node.FindElement("AudioEncoderConfiguration/SampleRate").Text())
+	if err != nil {
+		return streams, profiles, name, err
+	}
+
+	r := fieldReader{}
+	nodes := doc.Root().FindElements("/Envelope/Body/GetProfilesResponse/Profiles")
 
-			streams = append(streams, stream)
+	for _, node := range nodes {
+		var stream Stream
+		stream.Token = r.attr(node, "token")
+		stream.Name = r.text(node, "Name")
+
+		if source := node.FindElement("VideoSourceConfiguration/SourceToken"); source != nil {
+			stream.Source = source.Text()
+		}
+
+		// video, audio and rate control are optional in a profile
+		if video := node.SelectElement("VideoEncoderConfiguration"); video != nil {
+			stream.Video.Encoding = r.text(video, "Encoding")
+			stream.Video.Quality = r.float64Value(video, "Quality")
+			stream.Video.Resolution.Width = r.uint32Value(video, "Resolution/Width")
+			stream.Video.Resolution.Height = r.uint32Value(video, "Resolution/Height")
+
+			if rate := video.SelectElement("RateControl"); rate != nil {
+				stream.Video.RateControl.FrameRateLimit = r.uint32Value(rate, "FrameRateLimit")
+				stream.Video.RateControl.EncodingInterval = r.uint32Value(rate, "EncodingInterval")
+				stream.Video.RateControl.BitrateLimit = r.uint32Value(rate, "BitrateLimit")
+			}
 		}
 
-		return streams, profiles, name, nil
+		if audio := node.SelectElement("AudioEncoderConfiguration"); audio != nil {
+			stream.Audio.Bitrate = r.uint32Value(audio, "Bitrate")
+			stream.Audio.Encoding = r.text(audio, "Encoding")
+			stream.Audio.SampleRate = r.uint32Value(audio, "SampleRate")
+		}
+
+		if r.err != nil {
+			return streams, profiles, name, r.err
+		}
+
+		name = stream.Name
+		profiles[stream.Name] = stream.Token
+		streams = append(streams, stream)
 	}
 
-	return streams, profiles, name, err
+	return streams, profiles, name, nil
 }
 
-func getPTZProfile(dev *goonvif.Device) (PTZConfig, error) {
+func getPTZProfile(ctx context.Context, dev *onvifDevice) (PTZConfig, error) {
 	getConfigurations := ptz.GetConfigurations{}
-	getConfigurationsResponseXML, err := dev.CallMethod(getConfigurations)
-	
-	xml := readResponse(getConfigurationsResponseXML)
-	// fmt.Println(xml)
-	doc := etree.NewDocument()
+	doc, err := dev.call(ctx, getConfigurations)
+
+	if err != nil {
+		return emptyPTZConfig(), err
+	}
+
+	r := fieldReader{}
+	config := r.element(doc.Root(), "/Envelope/Body/GetConfigurationsResponse/PTZConfiguration")
+
+	if r.err != nil {
+		return emptyPTZConfig(), r.err
+	}
+
+	token := config.SelectAttrValue("token", "")
+
+	// limits are optional, a camera without limits reports 0 ~ 0
+	var pan PTZRange
+	var tilt PTZRange
+	var zoom PTZRange
+	if limits := config.FindElement("PanTiltLimits/Range"); limits != nil {
+		pan.Min = r.float32Value(limits, "XRange/Min")
+		pan.Max = r.float32Value(limits, "XRange/Max")
+		tilt.Min = r.float32Value(limits, "YRange/Min")
+		tilt.Max = r.float32Value(limits, "YRange/Max")
+	}
+	if limits := config.FindElement("ZoomLimits/Range"); limits != nil {
+		zoom.Min = r.float32Value(limits, "XRange/Min")
+		zoom.Max = r.float32Value(limits, "XRange/Max")
+	}
+
+	if r.err != nil {
+		return emptyPTZConfig(), r.err
+	}
+
+	ptz := PTZConfig {
+		Pan: pan,
+		Tilt: tilt,
+		Zoom: zoom,
+	}
+
+	nodeToken := ""
+	if node := config.SelectElement("NodeToken"); node != nil {
+		nodeToken = node.Text()
+	}
+
+	// node and options are optional, missing capabilities are left empty
+	if err := getPTZNode(ctx, dev, nodeToken, &ptz); err != nil {
+		logWarn("Cannot get PTZ node:", err)
+	}
+	if err := getPTZOptions(ctx, dev, token, &ptz); err != nil {
+		logWarn("Cannot get PTZ configuration options:", err)
+	}
 
-	if err := doc.ReadFromString(xml); err == nil {
-		config := doc.Root().FindElement("/Envelope/Body/GetConfigurationsResponse/PTZConfiguration")
-		// token := config.SelectAttr("token").Value
-		var pan PTZRange
-		var tilt PTZRange
-		var zoom PTZRange
-		pan.Min = str2float32(config.FindElement("PanTiltLimits/Range/XRange/Min").Text())
-		pan.Max = str2float32(config.FindElement("PanTiltLimits/Range/XRange/Max").Text())
-		tilt.Min = str2float32(config.FindElement("PanTiltLimits/Range/YRange/Min").Text())
-		tilt.Max = str2float32(config.FindElement("PanTiltLimits/Range/YRange/Max").Text())
-		zoom.Min = str2float32(config.FindElement("ZoomLimits/Range/XRange/Min").Text())
-		zoom.Max = str2float32(config.FindElement("ZoomLimits/Range/XRange/Max").Text())
-
-		// speed, err := getPTZSpeed(dev, token)
-
-		// if err == nil {
-			ptz := PTZConfig {
-				Pan: pan,
-				Tilt: tilt,
-				Zoom: zoom,
-				// Speed: speed,
+	ptz.Absolute = len(ptz.Spaces.AbsolutePanTilt) > 0 || len(ptz.Spaces.AbsoluteZoom) > 0
+	ptz.Relative = len(ptz.Spaces.RelativePanTilt) > 0 || len(ptz.Spaces.RelativeZoom) > 0
+	ptz.Continuous = len(ptz.Spaces.ContinuousPanTilt) > 0 || len(ptz.Spaces.ContinuousZoom) > 0
+	if len(ptz.Spaces.PanTiltSpeed) > 0 {
+		ptz.PanTiltSpeed = ptz.Spaces.PanTiltSpeed[0].X
+	}
+	if len(ptz.Spaces.ZoomSpeed) > 0 {
+		ptz.ZoomSpeed = ptz.Spaces.ZoomSpeed[0].X
+	}
+
+	return ptz, nil
+}
+
+// parse <Min>/<Max> of a range element, missing values are 0
+func parseRange(node *etree.Element) PTZRange {
+	var r PTZRange
+
+	if node == nil {
+		return r
+	}
+
+	if min := node.SelectElement("Min"); min != nil {
+		if f, err := strconv.ParseFloat(min.Text(), 32); err == nil {
+			r.Min = float32(f)
+		}
+	}
+	if max := node.SelectElement("Max"); max != nil {
+		if f, err := strconv.ParseFloat(max.Text(), 32); err == nil {
+			r.Max = float32(f)
+		}
+	}
+
+	return r
+}
+
+// parse SupportedPTZSpaces of a node or Spaces of configuration options
+func parseSpaces(node *etree.Element) PTZSpaces {
+	var spaces PTZSpaces
+
+	for _, child := range node.ChildElements() {
+		var space PTZSpace
+		if uri := child.SelectElement("URI"); uri != nil {
+			space.Uri = uri.Text()
+		}
+		space.X = parseRange(child.SelectElement("XRange"))
+		space.Y = parseRange(child.SelectElement("YRange"))
+
+		switch child.Tag {
+		case "AbsolutePanTiltPositionSpace":
+			spaces.AbsolutePanTilt = append(spaces.AbsolutePanTilt, space)
+		case "AbsoluteZoomPositionSpace":
+			spaces.AbsoluteZoom = append(spaces.AbsoluteZoom, space)
+		case "RelativePanTiltTranslationSpace":
+			spaces.RelativePanTilt = append(spaces.RelativePanTilt, space)
+		case "RelativeZoomTranslationSpace":
+			spaces.RelativeZoom = append(spaces.RelativeZoom, space)
+		case "ContinuousPanTiltVelocitySpace":
+			spaces.ContinuousPanTilt = append(spaces.ContinuousPanTilt, space)
+		case "ContinuousZoomVelocitySpace":
+			spaces.ContinuousZoom = append(spaces.ContinuousZoom, space)
+		case "PanTiltSpeedSpace":
+			spaces.PanTiltSpeed = append(spaces.PanTiltSpeed, space)
+		case "ZoomSpeedSpace":
+			spaces.ZoomSpeed = append(spaces.ZoomSpeed, space)
+		}
+	}
+
+	return spaces
+}
+
+// parse ISO 8601 duration like PT1M30.5S to seconds
+func parseDuration(s string) float32 {
+	s = strings.TrimPrefix(s, "PT")
+	var total float64
+	num := ""
+	for _, c := range s {
+		switch c {
+		case 'H', 'M', 'S':
+			f, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return 0
+			}
+			switch c {
+			case 'H':
+				total += f * 3600
+			case 'M':
+				total += f * 60
+			case 'S':
+				total += f
 			}
-			return ptz, nil
-		// }
+			num = ""
+		default:
+			num += string(c)
+		}
+	}
+	return float32(total)
+}
+
+// Fill each kind of spaces which spaces left empty with the spaces of other
+func mergeSpaces(spaces *PTZSpaces, other PTZSpaces) {
+	merge := func(space *[]PTZSpace, other []PTZSpace) {
+		if len(*space) == 0 {
+			*space = other
+		}
 	}
 
-	return emptyPTZConfig(), err
+	merge(&spaces.AbsolutePanTilt, other.AbsolutePanTilt)
+	merge(&spaces.AbsoluteZoom, other.AbsoluteZoom)
+	merge(&spaces.RelativePanTilt, other.RelativePanTilt)
+	merge(&spaces.RelativeZoom, other.RelativeZoom)
+	merge(&spaces.ContinuousPanTilt, other.ContinuousPanTilt)
+	merge(&spaces.ContinuousZoom, other.ContinuousZoom)
+	merge(&spaces.PanTiltSpeed, other.PanTiltSpeed)
+	merge(&spaces.ZoomSpeed, other.ZoomSpeed)
 }
 
-// func getPTZSpeed(dev *goonvif.Device, token string) (PTZRange, error) {
-// 	getConfigurationOptions := ptz.GetConfigurationOptions{ProfileToken: onvif.ReferenceToken(token)}
-// 	getConfigurationOptionsResponseXML, err := dev.CallMethod(getConfigurationOptions)
-	
-// 	xml := readResponse(getConfigurationOptionsResponseXML)
+// Seconds to ISO 8601 duration, e.g. PT1.5S
+func formatDuration(seconds float64) string {
+	return "PT" + strconv.FormatFloat(seconds, 'f', -1, 64) + "S"
+}
 
-// 	fmt.Println(xml)
-// 	doc := etree.NewDocument()
+func getPTZNode(ctx context.Context, dev *onvifDevice, nodeToken string, config *PTZConfig) (error) {
+	getNodes := ptz.GetNodes{}
+	doc, err := dev.call(ctx, getNodes)
 
-// 	if err := doc.ReadFromString(xml); err == nil {
-// 		space := doc.Root().FindElement("/Envelope/Body/GetConfigurationOptionsResponse/PTZConfigurationOptions/Spaces/ZoomSpeedSpace")
+	if err != nil {
+		return err
+	}
 
-// 		var speed PTZRange
+	nodes := doc.Root().FindElements("/Envelope/Body/GetNodesResponse/PTZNode")
 
-// 		speed.Min = str2float32(space.FindElement("Range/XRange/Min").Text())
-// 		speed.Max = str2float32(space.FindElement("Range/XRange/Max").Text())
+	if len(nodes) == 0 {
+		return &MissingFieldError{Path: "/Envelope/Body/GetNodesResponse/PTZNode"}
+	}
 
-// 		return speed, nil
-// 	}
+	// use the node of the configuration, or the first one
+	node := nodes[0]
+	for _, n := range nodes {
+		if n.SelectAttrValue("token", "") == nodeToken {
+			node = n
+			break
+		}
+	}
 
-// 	empty := PTZRange{Min: 0, Max: 0}
-// 	return empty, err
-// }
+	config.FixedHomePosition = node.SelectAttrValue("FixedHomePosition", "") == "true"
+
+	if spaces := node.SelectElement("SupportedPTZSpaces"); spaces != nil {
+		config.Spaces = parseSpaces(spaces)
+	}
+	if max := node.SelectElement("MaximumNumberOfPresets"); max != nil {
+		if i, err := strconv.Atoi(max.Text()); err == nil {
+			config.MaxPresets = i
+		}
+	}
+	if home := node.SelectElement("HomeSupported"); home != nil {
+		config.HomeSupported = home.Text() == "true"
+	}
+	config.PresetTourOperations = make([]string, 0)
+	if tours := node.FindElement("Extension/SupportedPresetTour"); tours != nil {
+		if max := tours.SelectElement("MaximumNumberOfPresetTours"); max != nil {
+			if i, err := strconv.Atoi(max.Text()); err == nil {
+				config.MaxPresetTours = i
+			}
+		}
+		for _, operation := range tours.SelectElements("PTZPresetTourOperation") {
+			config.PresetTourOperations = append(config.PresetTourOperations, operation.Text())
+		}
+	}
+	config.AuxiliaryCommands = make([]string, 0)
+	for _, aux := range node.SelectElements("AuxiliaryCommands") {
+		config.AuxiliaryCommands = append(config.AuxiliaryCommands, aux.Text())
+	}
+
+	return nil
+}
+
+// ptz.GetConfigurationOptions of the SDK sends ProfileToken instead of ConfigurationToken
+type getConfigurationOptions struct {
+	XMLName string `xml:"tptz:GetConfigurationOptions"`
+	ConfigurationToken onvif.ReferenceToken `xml:"tptz:ConfigurationToken"`
+}
+
+func getPTZOptions(ctx context.Context, dev *onvifDevice, token string, config *PTZConfig) (error) {
+	getOptions := getConfigurationOptions{ConfigurationToken: onvif.ReferenceToken(token)}
+	doc, err := dev.callAt(ctx, "ptz", getOptions)
+
+	if err != nil {
+		return err
+	}
+
+	r := fieldReader{}
+	options := r.element(doc.Root(), "/Envelope/Body/GetConfigurationOptionsResponse/PTZConfigurationOptions")
+
+	if r.err != nil {
+		return r.err
+	}
+
+	// spaces of the node take priority
+	if spaces := options.SelectElement("Spaces"); spaces != nil {
+		mergeSpaces(&config.Spaces, parseSpaces(spaces))
+	}
+	if timeout := options.SelectElement("PTZTimeout"); timeout != nil {
+		if min := timeout.SelectElement("Min"); min != nil {
+			config.Timeout.Min = parseDuration(min.Text())
+		}
+		if max := timeout.SelectElement("Max"); max != nil {
+			config.Timeout.Max = parseDuration(max.Text())
+		}
+	}
+
+	return nil
+}
 
 // func getProfilesSDK(ctx *context.Context, dev *goonvif.Device) ([]Stream, error) {
 // 	getProfiles := media.GetProfiles{}
@@ -295,240 +901,487 @@ func getPTZProfile(dev *goonvif.Device) (PTZConfig, error) {
 // 	return streams, err
 // }
 
-func getStatus(dev *goonvif.Device) (PTZStatus, error) {
+func getStatus(ctx context.Context, dev *onvifDevice) (PTZStatus, error) {
 	getStatus := ptz.GetStatus{}
-	getStatusResponseXML, err := dev.CallMethod(getStatus)
-	
-	xml := readResponse(getStatusResponseXML)
-	// fmt.Println(xml)
-	doc := etree.NewDocument()
-
-	if err := doc.ReadFromString(xml); err == nil {
-		var status PTZStatus
-		node := doc.Root().FindElement("/Envelope/Body/GetStatusResponse/PTZStatus")
-		pt := node.FindElement("Position/PanTilt")
+	doc, err := dev.call(ctx, getStatus)
 
-		status.Pan = str2float32(pt.SelectAttr("x").Value)
-		status.Tilt = str2float32(pt.SelectAttr("y").Value)
+	if err != nil {
+		return emptyStatus(), err
+	}
 
-		z := node.FindElement("Position/Zoom")
-		status.Zoom = str2float32(z.SelectAttr("x").Value)
+	r := fieldReader{}
+	var status PTZStatus
+	node := r.element(doc.Root(), "/Envelope/Body/GetStatusResponse/PTZStatus")
 
-		status.PTMoving = node.FindElement("MoveStatus/PanTilt").Text()
-		status.ZMoving = node.FindElement("MoveStatus/Zoom").Text()
+	if r.err != nil {
+		return emptyStatus(), r.err
+	}
 
-		status.Moving = (status.PTMoving == "moving" || status.ZMoving == "moving")
+	// position and move status are optional, e.g. no zoom on fixed lens
+	if pt := node.FindElement("Position/PanTilt"); pt != nil {
+		status.Pan = r.attrFloat32(pt, "x")
+		status.Tilt = r.attrFloat32(pt, "y")
+	}
+	if z := node.FindElement("Position/Zoom"); z != nil {
+		status.Zoom = r.attrFloat32(z, "x")
+	}
+	if pt := node.FindElement("MoveStatus/PanTilt"); pt != nil {
+		status.PTMoving = pt.Text()
+	}
+	if z := node.FindElement("MoveStatus/Zoom"); z != nil {
+		status.ZMoving = z.Text()
+	}
 
-		return status, nil
+	if r.err != nil {
+		return emptyStatus(), r.err
 	}
 
-	return emptyStatus(), err
+	status.Moving = (status.PTMoving == "moving" || status.ZMoving == "moving")
+
+	return status, nil
 }
 
-func getPresets(dev *goonvif.Device, token string) ([]PTZPreset, error) {
+func getPresets(ctx context.Context, dev *onvifDevice, token string) ([]PTZPreset, error) {
 	getPresets := ptz.GetPresets{ProfileToken: onvif.ReferenceToken(token)}
-	getPresetsResponseXML, err := dev.CallMethod(getPresets)
-	
-	xml := readResponse(getPresetsResponseXML)
-	// fmt.Println(xml)
-	doc := etree.NewDocument()
-	presets := make([]PTZPreset, 0)
+	doc, err := dev.call(ctx, getPresets)
+
+	if err != nil {
+		return nil, err
+	}
 
-	if err := doc.ReadFromString(xml); err == nil {
+	r := fieldReader{}
+	presets := make([]PTZPreset, 0)
+	nodes := doc.Root().FindElements("/Envelope/Body/GetPresetsResponse/Preset")
+	
+	for _, node := range nodes {
 		var preset PTZPreset
-		nodes := doc.Root().FindElements("/Envelope/Body/GetPresetsResponse/Preset")
-		
-		for _, node := range nodes {
-			preset.Id = node.SelectAttr("token").Value
-			preset.Name = node.SelectElement("Name").Text()
-			preset.PTZPosition.Pan = str2float32(node.FindElement("PTZPosition/PanTilt").SelectAttr("x").Value)
-			preset.PTZPosition.Tilt = str2float32(node.FindElement("PTZPosition/PanTilt").SelectAttr("y").Value)
-			preset.PTZPosition.Zoom = str2float32(node.FindElement("PTZPosition/Zoom").SelectAttr("x").Value)
+		preset.Id = r.attr(node, "token")
 
-			presets = append(presets, preset)
+		// name and position are optional in a preset
+		if name := node.SelectElement("Name"); name != nil {
+			preset.Name = name.Text()
+		}
+		if pt := node.FindElement("PTZPosition/PanTilt"); pt != nil {
+			preset.PTZPosition.Pan = r.attrFloat32(pt, "x")
+			preset.PTZPosition.Tilt = r.attrFloat32(pt, "y")
 		}
-		return presets, nil
+		if z := node.FindElement("PTZPosition/Zoom"); z != nil {
+			preset.PTZPosition.Zoom = r.attrFloat32(z, "x")
+		}
+
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		presets = append(presets, preset)
 	}
 
-	return nil, err
+	return presets, nil
 }
 
-func getStreamUri(dev *goonvif.Device, token string) (string, error) {
+func getStreamUri(ctx context.Context, dev *onvifDevice, token string) (string, error) {
 	transport := onvif.Transport{Protocol: onvif.TransportProtocol("RTSP"), Tunnel: nil}
 	setup := onvif.StreamSetup{Stream: onvif.StreamType("RTP-Unicast"), Transport: transport}
 	getStreamUri := media.GetStreamUri{ProfileToken: onvif.ReferenceToken(token), StreamSetup: setup}
-	getStreamUriResponseXML, err := dev.CallMethod(getStreamUri)
-	
-	xml := readResponse(getStreamUriResponseXML)
-	// fmt.Println(xml)
-	doc := etree.NewDocument()
+	doc, err := dev.call(ctx, getStreamUri)
 
-	if err := doc.ReadFromString(xml); err == nil {
-		uri := doc.Root().FindElement("/Envelope/Body/GetStreamUriResponse/MediaUri/Uri").Text()
+	if err != nil {
+		return "", err
+	}
 
-		return uri, nil
+	r := fieldReader{}
+	uri := r.text(doc.Root(), "/Envelope/Body/GetStreamUriResponse/MediaUri/Uri")
+
+	return uri, r.err
+}
+
+func getSnapshotUri(ctx context.Context, dev *onvifDevice, token string) (string, error) {
+	getSnapshotUri := media.GetSnapshotUri{ProfileToken: onvif.ReferenceToken(token)}
+	doc, err := dev.call(ctx, getSnapshotUri)
+
+	if err != nil {
+		return "", err
 	}
 
-	return "", err
+	r := fieldReader{}
+	uri := r.text(doc.Root(), "/Envelope/Body/GetSnapshotUriResponse/MediaUri/Uri")
+
+	return uri, r.err
 }
 
-func stop(dev *goonvif.Device, token string) (error) {
+func stop(ctx context.Context, dev *onvifDevice, token string) (error) {
 	stop := ptz.Stop{ProfileToken: onvif.ReferenceToken(token), PanTilt: true, Zoom: true}
-	stopResponseXML, err := dev.CallMethod(stop)
-	
-	xml := readResponse(stopResponseXML)
-	// fmt.Println(xml)
-	doc := etree.NewDocument()
+	doc, err := dev.call(ctx, stop)
 
-	if err := doc.ReadFromString(xml); err == nil {
-		res := doc.Root().FindElement("/Envelope/Body/StopResponse")
+	return checkResponse(doc, err, "StopResponse")
+}
 
-		if res != nil {
-			return nil
-		}
+// Speed of the SDK request has no omitempty, the camera default speed is used without Speed
+type gotoPresetRequest struct {
+	XMLName string `xml:"tptz:GotoPreset"`
+	ProfileToken string `xml:"tptz:ProfileToken"`
+	PresetToken string `xml:"tptz:PresetToken"`
+	Speed *onvif.PTZSpeed `xml:"tptz:Speed,omitempty"`
+}
 
-		return errors.New("stopResponse not found")
+// speed 0 means the default speed of the camera
+func gotoPreset(ctx context.Context, dev *onvifDevice, token string, id string, speed float64) (string, error) {
+	gotoPreset := gotoPresetRequest{ProfileToken: token, PresetToken: id}
+	if speed > 0 {
+		gotoPreset.Speed = &onvif.PTZSpeed{PanTilt: onvif.Vector2D{X: speed, Y: speed}, Zoom: onvif.Vector1D{X: speed}}
 	}
+	doc, err := dev.callAt(ctx, "ptz", gotoPreset)
 
-	return err
+	return id, checkResponse(doc, err, "GotoPresetResponse")
 }
 
-func gotoPreset(dev *goonvif.Device, token string, id string) (string, error) {
-	gotoPreset := ptz.GotoPreset{ProfileToken: onvif.ReferenceToken(token), PresetToken: onvif.ReferenceToken(id)}
-	gotoPresetResponseXML, err := dev.CallMethod(gotoPreset)
-	
-	xml := readResponse(gotoPresetResponseXML)
-	// fmt.Println(xml)
-	doc := etree.NewDocument()
+func setPreset(ctx context.Context, dev *onvifDevice, token string, id string, name string) (string, error) {
+	setPreset := ptz.SetPreset{ProfileToken: onvif.ReferenceToken(token), PresetName: xsd.String(name), PresetToken: onvif.ReferenceToken(id)}
+	doc, err := dev.call(ctx, setPreset)
 
-	if err := doc.ReadFromString(xml); err == nil {
-		res := doc.Root().FindElement("/Envelope/Body/GotoPresetResponse")
+	if err != nil {
+		return id, err
+	}
 
-		if res != nil {
-			return id, nil
-		}
+	r := fieldReader{}
+	id = r.text(doc.Root(), "/Envelope/Body/SetPresetResponse/PresetToken")
+
+	return id, r.err
+}
+
+func removePreset(ctx context.Context, dev *onvifDevice, token string, id string) (error) {
+	removePreset := ptz.RemovePreset{ProfileToken: onvif.ReferenceToken(token), PresetToken: onvif.ReferenceToken(id)}
+	doc, err := dev.call(ctx, removePreset)
+
+	return checkResponse(doc, err, "RemovePresetResponse")
+}
+
+func sendAuxiliaryCommand(ctx context.Context, dev *onvifDevice, token string, command string) (string, error) {
+	sendCommand := ptz.SendAuxiliaryCommand{ProfileToken: onvif.ReferenceToken(token), AuxiliaryData: onvif.AuxiliaryData(command)}
+	doc, err := dev.call(ctx, sendCommand)
 
-		return id, errors.New("gotoPresetResponse not found")
+	if err != nil {
+		return "", err
+	}
+
+	r := fieldReader{}
+	node := r.element(doc.Root(), "/Envelope/Body/SendAuxiliaryCommandResponse")
+
+	if r.err != nil {
+		return "", r.err
 	}
 
-	return id, err
+	// the response data is optional
+	if response := node.SelectElement("AuxiliaryResponse"); response != nil {
+		return strings.TrimSpace(response.Text()), nil
+	}
+
+	return "", nil
+}
+
+// ptz.GotoHomePosition of the SDK always sends a Speed element with zero PanTilt and Zoom,
+// some cameras then do not move. Without Speed the camera uses its default speed
+type gotoHomeRequest struct {
+	XMLName string `xml:"tptz:GotoHomePosition"`
+	ProfileToken string `xml:"tptz:ProfileToken"`
 }
 
-func gotoPosition(dev *goonvif.Device, token string, p float64, t float64, z float64, ps float64, ts float64, zs float64) (error) {
+func gotoHome(ctx context.Context, dev *onvifDevice, token string) (error) {
+	doc, err := dev.callAt(ctx, "ptz", gotoHomeRequest{ProfileToken: token})
+
+	return checkResponse(doc, err, "GotoHomePositionResponse")
+}
+
+func setHome(ctx context.Context, dev *onvifDevice, token string) (error) {
+	setHome := ptz.SetHomePosition{ProfileToken: onvif.ReferenceToken(token)}
+	doc, err := dev.call(ctx, setHome)
+
+	return checkResponse(doc, err, "SetHomePositionResponse")
+}
+
+func gotoPosition(ctx context.Context, dev *onvifDevice, token string, p float64, t float64, z float64, ps float64, ts float64, zs float64) (error) {
 	position := onvif.PTZVector{PanTilt: onvif.Vector2D{X: p, Y: t}, Zoom: onvif.Vector1D{X: z}}
 	speed := onvif.PTZSpeed{PanTilt: onvif.Vector2D{X: ps, Y: ts}, Zoom: onvif.Vector1D{X: zs}}
 	gotoPosition := ptz.AbsoluteMove{ProfileToken: onvif.ReferenceToken(token), Position: position, Speed: speed}
-	gotoPositionResponseXML, err := dev.CallMethod(gotoPosition)
-	
-	xml := readResponse(gotoPositionResponseXML)
-	// fmt.Println(xml)
-	doc := etree.NewDocument()
+	doc, err := dev.call(ctx, gotoPosition)
+
+	return checkResponse(doc, err, "AbsoluteMoveResponse")
+}
+
+func moveRelativePosition(ctx context.Context, dev *onvifDevice, token string, p float64, t float64, z float64, ps float64, ts float64, zs float64) (error) {
+	position := onvif.PTZVector{PanTilt: onvif.Vector2D{X: p, Y: t}, Zoom: onvif.Vector1D{X: z}}
+	speed := onvif.PTZSpeed{PanTilt: onvif.Vector2D{X: ps, Y: ts}, Zoom: onvif.Vector1D{X: zs}}
+	gotoPosition := ptz.RelativeMove{ProfileToken: onvif.ReferenceToken(token), Translation: position, Speed: speed}
+	doc, err := dev.call(ctx, gotoPosition)
+
+	return checkResponse(doc, err, "RelativeMoveResponse")
+}
+
+func continuousMove(ctx context.Context, dev *onvifDevice, token string, ps float64, ts float64, zs float64, timeout float64) (error) {
+	if timeout <= 0 {
+		timeout = defaultContinuousTimeout
+	}
+
+	velocity := onvif.PTZSpeed{PanTilt: onvif.Vector2D{X: ps, Y: ts}, Zoom: onvif.Vector1D{X: zs}}
+	duration := xsd.Duration(formatDuration(timeout))
+	continuousMove := ptz.ContinuousMove{ProfileToken: onvif.ReferenceToken(token), Velocity: velocity, Timeout: duration}
+	doc, err := dev.call(ctx, continuousMove)
+
+	return checkResponse(doc, err, "ContinuousMoveResponse")
+}
+
+func getDeviceInformation(ctx context.Context, dev *onvifDevice) (DeviceInfo, error) {
+	getDeviceInformation := device.GetDeviceInformation{}
+	doc, err := dev.call(ctx, getDeviceInformation)
 
-	if err := doc.ReadFromString(xml); err == nil {
-		res := doc.Root().FindElement("/Envelope/Body/AbsoluteMoveResponse")
+	var info DeviceInfo
 
-		if res != nil {
-			return nil
+	if err != nil {
+		return info, err
+	}
+
+	r := fieldReader{}
+	node := r.element(doc.Root(), "/Envelope/Body/GetDeviceInformationResponse")
+	info.Manufacturer = r.text(node, "Manufacturer")
+	info.Model = r.text(node, "Model")
+	info.FirmwareVersion = r.text(node, "FirmwareVersion")
+	info.SerialNumber = r.text(node, "SerialNumber")
+	info.HardwareId = r.text(node, "HardwareId")
+
+	return info, r.err
+}
+
+func getServices(ctx context.Context, dev *onvifDevice) ([]DeviceService, error) {
+	getServices := device.GetServices{IncludeCapability: false}
+	doc, err := dev.call(ctx, getServices)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := fieldReader{}
+	services := make([]DeviceService, 0)
+	nodes := doc.Root().FindElements("/Envelope/Body/GetServicesResponse/Service")
+
+	for _, node := range nodes {
+		var service DeviceService
+		service.Namespace = r.text(node, "Namespace")
+		service.XAddr = r.text(node, "XAddr")
+
+		// version is optional
+		if version := node.SelectElement("Version"); version != nil {
+			service.Version = r.text(version, "Major") + "." + r.text(version, "Minor")
 		}
 
-		return errors.New("absoluteMoveResponse not found")
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		services = append(services, service)
 	}
 
-	return err
+	return services, nil
 }
 
+func getSystemDateAndTime(ctx context.Context, dev *onvifDevice) (DeviceTime, error) {
+	getSystemDateAndTime := device.GetSystemDateAndTime{}
+	doc, err := dev.call(ctx, getSystemDateAndTime)
 
-func moveRelativePosition(dev *goonvif.Device, token string, p float64, t float64, z float64, ps float64, ts float64, zs float64) (error) {
-	position := onvif.PTZVector{PanTilt: onvif.Vector2D{X: p, Y: t}, Zoom: onvif.Vector1D{X: z}}
-	speed := onvif.PTZSpeed{PanTilt: onvif.Vector2D{X: ps, Y: ts}, Zoom: onvif.Vector1D{X: zs}}
-	gotoPosition := ptz.RelativeMove{ProfileToken: onvif.ReferenceToken(token), Translation: position, Speed: speed}
-	gotoPositionResponseXML, err := dev.CallMethod(gotoPosition)
-	
-	xml := readResponse(gotoPositionResponseXML)
-	// fmt.Println(xml)
-	doc := etree.NewDocument()
+	var t DeviceTime
 
-	if err := doc.ReadFromString(xml); err == nil {
-		res := doc.Root().FindElement("/Envelope/Body/RelativeMoveResponse")
+	if err != nil {
+		return t, err
+	}
 
-		if res != nil {
-			return nil
+	r := fieldReader{}
+	node := r.element(doc.Root(), "/Envelope/Body/GetSystemDateAndTimeResponse/SystemDateAndTime")
+
+	if r.err != nil {
+		return t, r.err
+	}
+
+	if dateTimeType := node.SelectElement("DateTimeType"); dateTimeType != nil {
+		t.DateTimeType = dateTimeType.Text()
+	}
+	if daylight := node.SelectElement("DaylightSavings"); daylight != nil {
+		t.DaylightSavings = daylight.Text() == "true"
+	}
+	if tz := node.FindElement("TimeZone/TZ"); tz != nil {
+		t.TimeZone = tz.Text()
+	}
+
+	// UTC is optional, without it the clock offset is unknown
+	if utc := node.SelectElement("UTCDateTime"); utc != nil {
+		year := r.uint32Value(utc, "Date/Year")
+		month := r.uint32Value(utc, "Date/Month")
+		day := r.uint32Value(utc, "Date/Day")
+		hour := r.uint32Value(utc, "Time/Hour")
+		minute := r.uint32Value(utc, "Time/Minute")
+		second := r.uint32Value(utc, "Time/Second")
+
+		if r.err != nil {
+			return t, r.err
 		}
 
-		return errors.New("relativeResponse not found")
+		t.UTC = time.Date(int(year), time.Month(month), int(day), int(hour), int(minute), int(second), 0, time.UTC)
+		t.Offset = t.UTC.Sub(time.Now()).Seconds()
+	}
+
+	return t, nil
+}
+
+// Notify a move requested through the interface
+func (ptz *PTZControl) moved() {
+	ptz.lock.RLock()
+	on_move := ptz.on_move
+	ptz.lock.RUnlock()
+
+	if on_move != nil {
+		on_move()
 	}
+}
+
+// Call on_move on each move requested through the interface
+func (ptz *PTZControl) setOnMove(on_move func()) {
+	ptz.lock.Lock()
+	defer ptz.lock.Unlock()
+
+	ptz.on_move = on_move
+}
+
+// Token of the current profile
+func (ptz *PTZControl) token() string {
+	ptz.lock.RLock()
+	defer ptz.lock.RUnlock()
 
-	return err
+	return ptz.profiles[ptz.profile_name]
 }
 
 // Interface for Outside
 
-func NewPTZControl(ip string, port uint16, username string, password string) (*PTZControl, error) {
-	ctx := context.Background()
+// NewPTZControl connects to the camera, ctx bounds the connecting only
+func NewPTZControl(ctx context.Context, ip string, port uint16, username string, password string) (*PTZControl, error) {
 	ptzInfo := PTZInfo{Ip: ip, Port: port, Username: username, Password: password}
-	dev, err := goonvif.NewDevice(goonvif.DeviceParams{Xaddr: fmt.Sprintf("%s:%d", ip, port), Username: username, Password: password})
+	dev, err := newOnvifDevice(ctx, ptzInfo)
 	if err == nil {
-		streams, profiles, name, err := getMediaProfiles(dev)
+		var streams []Stream
+		var profiles map[string]string
+		var name string
+		streams, profiles, name, err = getMediaProfiles(ctx, dev)
 		// profiles, err := getProfilesSDK(&ctx, dev)
 
 		if err == nil {
-			ptz, err := getPTZProfile(dev)
-
-			if err == nil {
-				configs := PTZConfigs{
-					Streams: streams,
-					PTZ: ptz,
-				}
-
-				return &PTZControl{
-					ctx: ctx,
-					cam: dev,
-					connected: true,
-					info: ptzInfo,
-					configs: configs,
-					profiles: profiles,
-					profile_name: name,
-				}, nil
+			ptz, err := getPTZProfile(ctx, dev)
+
+			// camera without PTZ service still can be streamed
+			if err != nil {
+				logWarn("Cannot get PTZ configuration:", err)
+			}
+
+			configs := PTZConfigs{
+				Streams: streams,
+				PTZ: ptz,
+			}
+
+			// device information is for diagnosis only, missing parts are left empty
+			camera := PTZDevice{Capabilities: dev.GetServices()}
+			if camera.Info, err = getDeviceInformation(ctx, dev); err != nil {
+				logWarn("Cannot get device information:", err)
+			}
+			if camera.Services, err = getServices(ctx, dev); err != nil {
+				logWarn("Cannot get device services:", err)
+			}
+			if camera.Time, err = getSystemDateAndTime(ctx, dev); err != nil {
+				logWarn("Cannot get device time:", err)
 			}
+
+			return &PTZControl{
+				cam: dev,
+				connected: true,
+				info: ptzInfo,
+				configs: configs,
+				device: camera,
+				profiles: profiles,
+				profile_name: name,
+				lock: new(sync.RWMutex),
+			}, nil
 		}
 	}
 
 	return &PTZControl{
-		ctx: ctx,
 		cam: nil,
 		connected: false,
 		info: ptzInfo,
 		configs: emptyConfig(),
 		profiles: nil,
 		profile_name: "",
+		lock: new(sync.RWMutex),
 	}, err
 
 }
 
-func (ptz *PTZControl) SetProfile(name string) {
-	_, ok := ptz.profiles[name]
-	if ok {
-		ptz.profile_name = name
+// Connected is false for a camera which was not connected or did not answer the last request,
+// its control is connected again by the registry
+func (ptz *PTZControl) Connected() bool {
+	return ptz.connected && !ptz.cam.isLost()
+}
+
+// SetProfile changes the current profile, ErrNotFound if the camera has no profile name
+func (ptz *PTZControl) SetProfile(name string) error {
+	ptz.lock.Lock()
+	defer ptz.lock.Unlock()
+
+	if _, ok := ptz.profiles[name]; !ok {
+		return fmt.Errorf("%w: profile %s", ErrNotFound, name)
 	}
+	ptz.profile_name = name
+
+	return nil
+}
+
+// Profile returns the name of the current profile
+func (ptz *PTZControl) Profile() string {
+	ptz.lock.RLock()
+	defer ptz.lock.RUnlock()
+
+	return ptz.profile_name
 }
 
 func (ptz *PTZControl) GetConfigs() (map[string]interface{}, error) {
 	if !ptz.connected {
-		return not_connected(), errors.New("not connected")
+		return not_connected(), ErrNotConnected
 	}
 
 	return map[string]interface{}{"code": 200, "message": "PTZ config", "data": ptz.configs}, nil
 }
 
-func (ptz *PTZControl) IsMoving() (map[string]interface{}, error) {
+// GetDevice returns the device information cached at connecting, with the current device time
+func (ptz *PTZControl) GetDevice(ctx context.Context) (map[string]interface{}, error) {
+	if !ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	t, err := getSystemDateAndTime(ctx, ptz.cam)
+
+	ptz.lock.Lock()
+	if err == nil {
+		ptz.device.Time = t
+	}
+	device := ptz.device
+	ptz.lock.Unlock()
+
+	return map[string]interface{}{"code": 200, "message": "Device information", "data": device}, nil
+}
+
+func (ptz *PTZControl) IsMoving(ctx context.Context) (map[string]interface{}, error) {
 	if !ptz.connected {
-		return not_connected(), errors.New("not connected")
+		return not_connected(), ErrNotConnected
 	}
 
-	status, err := getStatus(ptz.cam)
+	status, err := getStatus(ctx, ptz.cam)
 
 	if err != nil {
-		return map[string]interface{}{"code": 404, "message": "Cannot get PTZ status", "data": nil}, err
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get PTZ status", "data": nil}, err
 	}
 	
 	data := Moving{Moving:status.Moving, PanTilt: status.PTMoving, Zoom: status.ZMoving}
@@ -536,116 +1389,321 @@ func (ptz *PTZControl) IsMoving() (map[string]interface{}, error) {
 	return map[string]interface{}{"code": 200, "message": "PTZ moving status", "data": data}, err
 }
 
-func (ptz *PTZControl) GetPosition() (map[string]interface{}, error) {
+func (ptz *PTZControl) GetPosition(ctx context.Context) (map[string]interface{}, error) {
 	if !ptz.connected {
-		return not_connected(), errors.New("not connected")
+		return not_connected(), ErrNotConnected
 	}
 
-	status, err := getStatus(ptz.cam)
+	status, err := getStatus(ctx, ptz.cam)
 
 	if err != nil {
-		return map[string]interface{}{"code": 404, "message": "Cannot get PTZ status", "data": nil}, err
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get PTZ status", "data": nil}, err
 	}
 	
 	return map[string]interface{}{"code": 200, "message": "PTZ position", "data": status}, nil
 }
 
-func (ptz *PTZControl) GetPresets() (map[string]interface{}, error) {
+func (ptz *PTZControl) GetPresets(ctx context.Context) (map[string]interface{}, error) {
 	if !ptz.connected {
-		return not_connected(), errors.New("not connected")
+		return not_connected(), ErrNotConnected
 	}
 
-	presets, err := getPresets(ptz.cam, ptz.profiles[ptz.profile_name])
+	presets, err := getPresets(ctx, ptz.cam, ptz.token())
 
 	data := map[string]interface{}{"Presets": presets}
 
 	if err != nil {
-		return map[string]interface{}{"code": 404, "message": "Cannot get PTZ presets", "data": data}, err
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get PTZ presets", "data": data}, err
 	}
 	
 	return map[string]interface{}{"code": 200, "message": "PTZ presets", "data": data}, nil
 }
 
-func (ptz *PTZControl) GetStreamUri() (map[string]interface{}, error) {
+// StreamUri returns the stream URI of the profile name without changing the current profile
+func (ptz *PTZControl) StreamUri(ctx context.Context, name string) (string, error) {
+	ptz.lock.RLock()
+	token, ok := ptz.profiles[name]
+	ptz.lock.RUnlock()
+
+	if !ok {
+		return "", fmt.Errorf("%w: profile %s", ErrNotFound, name)
+	}
 	if !ptz.connected {
-		return not_connected(), errors.New("not connected")
+		return "", ErrNotConnected
 	}
 
-	uri, err := getStreamUri(ptz.cam, ptz.profiles[ptz.profile_name])
+	return getStreamUri(ctx, ptz.cam, token)
+}
+
+func (ptz *PTZControl) GetStreamUri(ctx context.Context) (map[string]interface{}, error) {
+	if !ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	uri, err := getStreamUri(ctx, ptz.cam, ptz.token())
 
 	if err != nil {
-		return map[string]interface{}{"code": 404, "message": "Cannot get Stream URI", "data": nil}, err
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get Stream URI", "data": nil}, err
 	}
 	
 	return map[string]interface{}{"code": 200, "message": "Stream URI", "data": PTZUri{Uri: uri}}, nil
 }
 
-func (ptz *PTZControl) GotoPreset(id string) (map[string]interface{}, error) {
+func (ptz *PTZControl) GetSnapshotUri(ctx context.Context) (map[string]interface{}, error) {
+	if !ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	uri, err := getSnapshotUri(ctx, ptz.cam, ptz.token())
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get Snapshot URI", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Snapshot URI", "data": PTZUri{Uri: uri}}, nil
+}
+
+// GetCameraSnapshot fetches the JPEG of the snapshot URI of the camera with the credentials of
+// the camera, it returns the image and its content type
+func (ptz *PTZControl) GetCameraSnapshot(ctx context.Context) ([]byte, string, error) {
 	if !ptz.connected {
-		return not_connected(), errors.New("not connected")
+		return nil, "", ErrNotConnected
+	}
+
+	uri, err := getSnapshotUri(ctx, ptz.cam, ptz.token())
+	if err != nil {
+		return nil, "", err
+	}
+	if uri == "" {
+		return nil, "", fmt.Errorf("%w: camera has no snapshot URI", ErrUnsupported)
 	}
 
-	token, err := gotoPreset(ptz.cam, ptz.profiles[ptz.profile_name], id)
+	return fetchSnapshot(ctx, ptz.cam.client, uri, ptz.info.Username, ptz.info.Password)
+}
+
+func (ptz *PTZControl) GotoPreset(ctx context.Context, id string) (map[string]interface{}, error) {
+	if !ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	ptz.moved()
+
+	token, err := gotoPreset(ctx, ptz.cam, ptz.token(), id, 0)
 
 	if err != nil {
-		return map[string]interface{}{"code": 404, "message": "Cannot set the camera to preset position", "data": nil}, err
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot set the camera to preset position", "data": nil}, err
 	}
 	
 	return map[string]interface{}{"code": 200, "message": "Set the camera to preset position", "data": PTZPresetID{Id: token}}, nil
 }
 
-func (ptz *PTZControl) Stop() (map[string]interface{}, error) {
+// SetPreset saves the current position as preset, an empty id creates a new preset
+// and an existing id overwrites that preset
+func (ptz *PTZControl) SetPreset(ctx context.Context, id string, name string) (map[string]interface{}, error) {
+	if !ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	if name == "" {
+		err := fmt.Errorf("%w: preset name is required", ErrInvalidArgument)
+		return map[string]interface{}{"code": errorCode(err), "message": "Preset name is required", "data": nil}, err
+	}
+
+	token := ptz.token()
+
+	presets, err := getPresets(ctx, ptz.cam, token)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get PTZ presets", "data": nil}, err
+	}
+
+	if id == "" {
+		max := ptz.configs.PTZ.MaxPresets
+		if max > 0 && len(presets) >= max {
+			err := fmt.Errorf("%w: maximum number of presets reached", ErrOutOfRange)
+			return map[string]interface{}{"code": errorCode(err), "message": "Maximum number of presets reached", "data": nil}, err
+		}
+	} else {
+		found := false
+		for _, preset := range presets {
+			if preset.Id == id {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			err := fmt.Errorf("%w: preset %s", ErrNotFound, id)
+			return map[string]interface{}{"code": errorCode(err), "message": "Preset not found", "data": nil}, err
+		}
+	}
+
+	id, err = setPreset(ctx, ptz.cam, token, id, name)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot save PTZ preset", "data": nil}, err
+	}
+	
+	return map[string]interface{}{"code": 200, "message": "Save PTZ preset", "data": PTZPresetName{Id: id, Name: name}}, nil
+}
+
+func (ptz *PTZControl) RemovePreset(ctx context.Context, id string) (map[string]interface{}, error) {
+	if !ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	err := removePreset(ctx, ptz.cam, ptz.token(), id)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot remove PTZ preset", "data": nil}, err
+	}
+	
+	return map[string]interface{}{"code": 200, "message": "Remove PTZ preset", "data": PTZPresetID{Id: id}}, nil
+}
+
+// Stop ends any absolute, relative or continuous movement on both pan/tilt and zoom
+func (ptz *PTZControl) Stop(ctx context.Context) (map[string]interface{}, error) {
 	if !ptz.connected {
-		return not_connected(), errors.New("not connected")
+		return not_connected(), ErrNotConnected
 	}
 
-	err := stop(ptz.cam, ptz.profiles[ptz.profile_name])
+	ptz.moved()
+
+	err := stop(ctx, ptz.cam, ptz.token())
 
 	if err != nil {
-		return map[string]interface{}{"code": 404, "message": "Cannot stop PTZ movement", "data": nil}, err
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot stop PTZ movement", "data": nil}, err
 	}
 	
 	return map[string]interface{}{"code": 200, "message": "Stop PTZ", "data": nil}, nil
 }
 
-func (ptz *PTZControl) GotoPosition(p float64, t float64, z float64, ps float64, ts float64, zs float64) (map[string]interface{}, error) {
+func (ptz *PTZControl) GotoPosition(ctx context.Context, p float64, t float64, z float64, ps float64, ts float64, zs float64) (map[string]interface{}, error) {
 	if !ptz.connected {
-		return not_connected(), errors.New("not connected")
+		return not_connected(), ErrNotConnected
 	}
 
-	err := gotoPosition(ptz.cam, ptz.profiles[ptz.profile_name], p, t, z, ps, ts, zs)
+	ptz.moved()
+
+	err := gotoPosition(ctx, ptz.cam, ptz.token(), p, t, z, ps, ts, zs)
 
 	if err != nil {
-		return map[string]interface{}{"code": 404, "message": "Cannot set PTZ to position", "data": nil}, err
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot set PTZ to position", "data": nil}, err
 	}
 	
 	return map[string]interface{}{"code": 200, "message": "Set PTZ to position", "data": nil}, nil
 }
 
-func (ptz *PTZControl) GotoHome() (map[string]interface{}, error) {
+func (ptz *PTZControl) GotoHome(ctx context.Context) (map[string]interface{}, error) {
 	if !ptz.connected {
-		return not_connected(), errors.New("not connected")
+		return not_connected(), ErrNotConnected
 	}
 
-	err := gotoPosition(ptz.cam, ptz.profiles[ptz.profile_name], 0, 0, 0, 1, 1, 1)
+	ptz.moved()
+
+	token := ptz.token()
+
+	var err error
+	if ptz.configs.PTZ.HomeSupported {
+		err = gotoHome(ctx, ptz.cam, token)
+	} else {
+		// no home position on the node, (0, 0, 0) is the best guess
+		err = gotoPosition(ctx, ptz.cam, token, 0, 0, 0, 1, 1, 1)
+	}
 
 	if err != nil {
-		return map[string]interface{}{"code": 404, "message": "Cannot set PTZ to Home position", "data": nil}, err
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot set PTZ to Home position", "data": nil}, err
 	}
 	
 	return map[string]interface{}{"code": 200, "message": "Set PTZ to Home position", "data": nil}, nil
 }
 
-func (ptz *PTZControl) MoveRelativePosition(p float64, t float64, z float64, ps float64, ts float64, zs float64) (map[string]interface{}, error) {
+// SetHomePosition saves the current position as the home position of the camera
+func (ptz *PTZControl) SetHomePosition(ctx context.Context) (map[string]interface{}, error) {
+	if !ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	if !ptz.configs.PTZ.HomeSupported || ptz.configs.PTZ.FixedHomePosition {
+		err := fmt.Errorf("%w: home position cannot be set", ErrUnsupported)
+		return map[string]interface{}{"code": errorCode(err), "message": "Home position cannot be set", "data": nil}, err
+	}
+
+	err := setHome(ctx, ptz.cam, ptz.token())
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot set Home position", "data": nil}, err
+	}
+	
+	return map[string]interface{}{"code": 200, "message": "Set Home position", "data": nil}, nil
+}
+
+func (ptz *PTZControl) MoveRelativePosition(ctx context.Context, p float64, t float64, z float64, ps float64, ts float64, zs float64) (map[string]interface{}, error) {
 	if !ptz.connected {
-		return not_connected(), errors.New("not connected")
+		return not_connected(), ErrNotConnected
 	}
 
-	err := moveRelativePosition(ptz.cam, ptz.profiles[ptz.profile_name], p, t, z, ps, ts, zs)
+	ptz.moved()
+
+	err := moveRelativePosition(ctx, ptz.cam, ptz.token(), p, t, z, ps, ts, zs)
 
 	if err != nil {
-		return map[string]interface{}{"code": 404, "message": "Cannot set PTZ to relative position", "data": nil}, err
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot set PTZ to relative position", "data": nil}, err
 	}
 	
 	return map[string]interface{}{"code": 200, "message": "Set PTZ to relative position", "data": nil}, nil
 }
+
+
+// ContinuousMove moves the camera with the given velocities (-1.0 ~ 1.0) until Stop is called
+// or timeout (seconds) expires, timeout <= 0 means the default timeout
+func (ptz *PTZControl) ContinuousMove(ctx context.Context, ps float64, ts float64, zs float64, timeout float64) (map[string]interface{}, error) {
+	if !ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	ptz.moved()
+
+	err := continuousMove(ctx, ptz.cam, ptz.token(), ps, ts, zs, timeout)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot start PTZ continuous move", "data": nil}, err
+	}
+	
+	return map[string]interface{}{"code": 200, "message": "Start PTZ continuous move", "data": nil}, nil
+}
+
+// SendAuxiliaryCommand sends one of the AuxiliaryCommands advertised by the PTZ node (wiper, IR lamp, heater...)
+func (ptz *PTZControl) SendAuxiliaryCommand(ctx context.Context, command string) (map[string]interface{}, error) {
+	if !ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	commands := ptz.configs.PTZ.AuxiliaryCommands
+
+	if len(commands) == 0 {
+		err := fmt.Errorf("%w: no auxiliary commands advertised", ErrUnsupported)
+		return map[string]interface{}{"code": errorCode(err), "message": "No auxiliary commands supported", "data": nil}, err
+	}
+
+	found := false
+	for _, aux := range commands {
+		if aux == command {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		err := fmt.Errorf("%w: auxiliary command %q", ErrInvalidArgument, command)
+		return map[string]interface{}{"code": errorCode(err), "message": "Auxiliary command not supported", "data": nil}, err
+	}
+
+	response, err := sendAuxiliaryCommand(ctx, ptz.cam, ptz.token(), command)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot send auxiliary command", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Send auxiliary command", "data": PTZAuxCommand{Command: command, Response: response}}, nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeSpaces(t *testing.T) {
+	nodeZoom := []PTZSpace{{Uri: "node/zoom", X: PTZRange{Min: 0, Max: 1}}}
+	optionsZoom := []PTZSpace{{Uri: "options/zoom", X: PTZRange{Min: 0, Max: 2}}}
+	optionsPanTilt := []PTZSpace{{Uri: "options/pantilt", X: PTZRange{Min: -1, Max: 1}, Y: PTZRange{Min: -1, Max: 1}}}
+
+	// a zoom only node keeps its zoom spaces and gets the pan tilt spaces of the options
+	spaces := PTZSpaces{AbsoluteZoom: nodeZoom, ContinuousZoom: nodeZoom}
+	mergeSpaces(&spaces, PTZSpaces{AbsolutePanTilt: optionsPanTilt, AbsoluteZoom: optionsZoom, RelativeZoom: optionsZoom})
+
+	want := PTZSpaces{AbsolutePanTilt: optionsPanTilt, AbsoluteZoom: nodeZoom, RelativeZoom: optionsZoom, ContinuousZoom: nodeZoom}
+	if !reflect.DeepEqual(spaces, want) {
+		t.Errorf("merged spaces %+v, want %+v", spaces, want)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Errors of PTZControl, use errors.Is to check the kind of a SOAP Fault or HTTP error
+var (
+	ErrNotConnected = errors.New("not connected")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrUnsupported = errors.New("not supported")
+	ErrOutOfRange = errors.New("out of range")
+	ErrNotFound = errors.New("not found")
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+// Errors returned by parsing ONVIF responses
+
+// MissingFieldError is returned when a required element or attribute is not in the response
+type MissingFieldError struct {
+	Path string
+}
+
+func (e *MissingFieldError) Error() string {
+	return "missing field: " + e.Path
+}
+
+// InvalidFieldError is returned when an element or attribute cannot be converted to its type
+type InvalidFieldError struct {
+	Path string
+	Value string
+	Err error
+}
+
+func (e *InvalidFieldError) Error() string {
+	return fmt.Sprintf("invalid field: %s = %q", e.Path, e.Value)
+}
+
+func (e *InvalidFieldError) Unwrap() error {
+	return e.Err
+}
+
+// SOAPFault is returned when the camera answers with a SOAP Fault,
+// Subcodes are the nested fault subcodes from outer to inner, e.g. ter:InvalidArgVal, ter:NoProfile
+type SOAPFault struct {
+	Code string
+	Subcodes []string
+	Reason string
+}
+
+func (e *SOAPFault) Error() string {
+	codes := append([]string{e.Code}, e.Subcodes...)
+	if e.Reason == "" {
+		return "soap fault: " + strings.Join(codes, "/")
+	}
+	return "soap fault: " + strings.Join(codes, "/") + ": " + e.Reason
+}
+
+// ONVIF fault subcodes (without namespace prefix) and their kinds
+var faultKinds = map[string]error{
+	"NotAuthorized": ErrUnauthorized,
+	"FailedAuthentication": ErrUnauthorized,
+	"ActionNotSupported": ErrUnsupported,
+	"NoPTZProfile": ErrUnsupported,
+	"NoImagingForSource": ErrUnsupported,
+	"NotSupported": ErrUnsupported,
+	"InvalidPosition": ErrOutOfRange,
+	"InvalidSpeed": ErrOutOfRange,
+	"InvalidTranslation": ErrOutOfRange,
+	"InvalidVelocity": ErrOutOfRange,
+	"TimeoutNotSupported": ErrOutOfRange,
+	"InvalidPresetName": ErrInvalidArgument,
+	"PresetExist": ErrInvalidArgument,
+	"TooManyPresets": ErrOutOfRange,
+	"NoProfile": ErrNotFound,
+	"NoToken": ErrNotFound,
+	"NoEntity": ErrNotFound,
+	"NoConfig": ErrNotFound,
+	"InvalidArgVal": ErrInvalidArgument,
+	"InvalidArgs": ErrInvalidArgument,
+}
+
+// kind of the fault, the innermost known subcode wins, then the code of SOAP 1.1 faults
+// or of cameras which put the ter: code in Code
+func (e *SOAPFault) kind() error {
+	codes := append([]string{e.Code}, e.Subcodes...)
+	for i := len(codes) - 1; i >= 0; i-- {
+		code := strings.TrimSpace(codes[i])
+		if n := strings.LastIndex(code, ":"); n >= 0 {
+			code = code[n+1:]
+		}
+		if kind, ok := faultKinds[code]; ok {
+			return kind
+		}
+	}
+	return nil
+}
+
+func (e *SOAPFault) Is(target error) bool {
+	return target != nil && e.kind() == target
+}
+
+// HTTPError is returned when the camera answers with an HTTP error without SOAP Fault
+type HTTPError struct {
+	StatusCode int
+	Status string
+}
+
+func (e *HTTPError) Error() string {
+	return "http error: " + e.Status
+}
+
+func (e *HTTPError) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return target == ErrUnauthorized
+	case http.StatusNotImplemented:
+		return target == ErrUnsupported
+	}
+	return false
+}
+
+// Status of a request canceled by the client which disconnected, the client does not get it
+const statusClientClosed = 499
+
+// HTTP status of the error for the REST API
+func errorCode(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, ErrNotConnected):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusForbidden
+	case errors.Is(err, ErrUnsupported):
+		return http.StatusNotImplemented
+	case errors.Is(err, ErrOutOfRange), errors.Is(err, ErrInvalidArgument):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return statusClientClosed
+	}
+
+	var fault *SOAPFault
+	var httpErr *HTTPError
+	if errors.As(err, &fault) || errors.As(err, &httpErr) {
+		return http.StatusBadGateway
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/beevik/etree"
+)
+
+func TestParseFault(t *testing.T) {
+	tests := []struct {
+		name string
+		fault string
+		want SOAPFault
+		kind error
+	}{
+		{
+			name: "SOAP 1.2 subcodes",
+			fault: `<env:Fault><env:Code><env:Value>env:Sender</env:Value><env:Subcode><env:Value>ter:InvalidArgVal</env:Value><env:Subcode><env:Value>ter:NoProfile</env:Value></env:Subcode></env:Subcode></env:Code><env:Reason><env:Text xml:lang="en">No such profile</env:Text></env:Reason></env:Fault>`,
+			want: SOAPFault{Code: "env:Sender", Subcodes: []string{"ter:InvalidArgVal", "ter:NoProfile"}, Reason: "No such profile"},
+			kind: ErrNotFound,
+		},
+		{
+			name: "SOAP 1.2 ter code",
+			fault: `<env:Fault><env:Code><env:Value>ter:NotAuthorized</env:Value></env:Code><env:Reason><env:Text>Sender not authorized</env:Text></env:Reason></env:Fault>`,
+			want: SOAPFault{Code: "ter:NotAuthorized", Reason: "Sender not authorized"},
+			kind: ErrUnauthorized,
+		},
+		{
+			name: "SOAP 1.1",
+			fault: `<SOAP-ENV:Fault><faultcode>ter:ActionNotSupported</faultcode><faultstring>Optional Action Not Implemented</faultstring></SOAP-ENV:Fault>`,
+			want: SOAPFault{Code: "ter:ActionNotSupported", Reason: "Optional Action Not Implemented"},
+			kind: ErrUnsupported,
+		},
+		{
+			name: "unknown code",
+			fault: `<env:Fault><env:Code><env:Value>env:Receiver</env:Value></env:Code></env:Fault>`,
+			want: SOAPFault{Code: "env:Receiver"},
+		},
+	}
+
+	for _, test := range tests {
+		doc := etree.NewDocument()
+		if err := doc.ReadFromString(test.fault); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		fault := parseFault(doc.Root())
+		if !reflect.DeepEqual(*fault, test.want) {
+			t.Errorf("%s: %+v, want %+v", test.name, *fault, test.want)
+		}
+		if kind := fault.kind(); kind != test.kind {
+			t.Errorf("%s: kind %v, want %v", test.name, kind, test.kind)
+		}
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		err error
+		code int
+	}{
+		{nil, http.StatusOK},
+		{ErrNotConnected, http.StatusServiceUnavailable},
+		{fmt.Errorf("%w: profile main", ErrNotFound), http.StatusNotFound},
+		{ErrInvalidArgument, http.StatusBadRequest},
+		{ErrOutOfRange, http.StatusBadRequest},
+		{ErrUnsupported, http.StatusNotImplemented},
+		{&SOAPFault{Code: "env:Sender", Subcodes: []string{"ter:NotAuthorized"}}, http.StatusForbidden},
+		{&SOAPFault{Code: "ter:InvalidPosition"}, http.StatusBadRequest},
+		{&SOAPFault{Code: "env:Receiver"}, http.StatusBadGateway},
+		{&HTTPError{StatusCode: http.StatusUnauthorized}, http.StatusForbidden},
+		{&HTTPError{StatusCode: http.StatusNotImplemented}, http.StatusNotImplemented},
+		{&HTTPError{StatusCode: http.StatusInternalServerError}, http.StatusBadGateway},
+		{fmt.Errorf("post: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{context.Canceled, statusClientClosed},
+		{errors.New("empty response"), http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		if code := errorCode(test.err); code != test.code {
+			t.Errorf("%v: code %d, want %d", test.err, code, test.code)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Camera is a camera of the inventory with its PTZControl, it is connected again
+// when it is requested while disconnected or after a request got no answer
+type Camera struct {
+	config CameraConfig
+	ptz *PTZControl
+	// connect lock, the connection may take the whole call timeout
+	lock *sync.Mutex
+	state *sync.RWMutex
+}
+
+type CameraStatus struct {
+	CameraConfig
+	Connected bool `json:"connected"`
+}
+
+// Camera of a request or of a version 1 store, the password is hidden in CameraConfig JSON
+// and is kept in the secrets
+type cameraRecord struct {
+	CameraConfig
+	Password string `json:"password"`
+}
+
+// CameraRegistry keeps the cameras of the inventory by id
+type CameraRegistry struct {
+	cameras map[string]*Camera
+	store Store
+	secrets *Secrets
+	lock *sync.RWMutex
+}
+
+var gCameras = NewCameraRegistry(gStore, gSecrets)
+
+// Internal
+
+func (record cameraRecord) config() CameraConfig {
+	config := record.CameraConfig
+	config.Password = record.Password
+	return config
+}
+
+func newCamera(config CameraConfig) *Camera {
+	return &Camera{config: config, lock: new(sync.Mutex), state: new(sync.RWMutex)}
+}
+
+func (camera *Camera) connect(ctx context.Context) (*PTZControl, error) {
+	camera.lock.Lock()
+	defer camera.lock.Unlock()
+
+	if ptz := camera.current(); ptz != nil && ptz.Connected() {
+		return ptz, nil
+	}
+
+	config := camera.config
+	ptz, err := NewPTZControl(ctx, config.Ip, config.Port, config.Username, config.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	// the default profile of the camera is kept for an unknown profile
+	if config.Profile != "" {
+		if err := ptz.SetProfile(config.Profile); err != nil {
+			logWarn("Camera " + config.Id + ":", err)
+		}
+	}
+
+	camera.state.Lock()
+	camera.ptz = ptz
+	camera.state.Unlock()
+
+	return ptz, nil
+}
+
+func (camera *Camera) current() *PTZControl {
+	camera.state.RLock()
+	defer camera.state.RUnlock()
+
+	return camera.ptz
+}
+
+func (camera *Camera) status() CameraStatus {
+	ptz := camera.current()
+
+	return CameraStatus{CameraConfig: camera.config, Connected: ptz != nil && ptz.Connected()}
+}
+
+func validateCamera(config CameraConfig) error {
+	if config.Id == "" || config.Ip == "" {
+		return fmt.Errorf("%w: camera needs id and ip", ErrInvalidArgument)
+	}
+
+	return nil
+}
+
+func (registry *CameraRegistry) camera(id string) (*Camera, bool) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	camera, ok := registry.cameras[id]
+	return camera, ok
+}
+
+// Interface for Outside
+
+// NewCameraRegistry creates the registry of the cameras in store with their passwords in secrets
+func NewCameraRegistry(store Store, secrets *Secrets) *CameraRegistry {
+	registry := &CameraRegistry{cameras: make(map[string]*Camera), store: store, secrets: secrets, lock: new(sync.RWMutex)}
+
+	records, err := store.List(bucketCameras)
+	if err != nil {
+		logWarn("Cannot load cameras:", err)
+	}
+
+	for id, data := range records {
+		var config CameraConfig
+		if err := json.Unmarshal(data, &config); err != nil {
+			logWarn("Cannot load camera " + id + ":", err)
+			continue
+		}
+		config.Password = secrets.Get(id)
+		registry.cameras[id] = newCamera(config)
+	}
+
+	return registry
+}
+
+// LoadCameraRegistry migrates the store with the config file and creates the registry,
+// cameras are connected in background
+func LoadCameraRegistry(config Config, store Store, secrets *Secrets) (*CameraRegistry, error) {
+	err := migrateStore(store, secrets, config)
+
+	registry := NewCameraRegistry(store, secrets)
+
+	for _, camera := range registry.cameras {
+		go func(camera *Camera) {
+			if _, err := camera.connect(context.Background()); err != nil {
+				logWarn("Cannot connect to camera " + camera.config.Id + ":", err)
+			}
+		}(camera)
+	}
+
+	return registry, err
+}
+
+// Connect returns the PTZControl of the camera, connecting it if needed
+func (registry *CameraRegistry) Connect(ctx context.Context, id string) (*PTZControl, error) {
+	camera, ok := registry.camera(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: camera %s", ErrNotFound, id)
+	}
+
+	return camera.connect(ctx)
+}
+
+func (registry *CameraRegistry) GetCameras() (map[string]interface{}, error) {
+	registry.lock.RLock()
+	cameras := make([]CameraStatus, 0, len(registry.cameras))
+	for _, camera := range registry.cameras {
+		cameras = append(cameras, camera.status())
+	}
+	registry.lock.RUnlock()
+
+	sort.Slice(cameras, func(i, j int) bool { return cameras[i].Id < cameras[j].Id })
+
+	return map[string]interface{}{"code": 200, "message": "Cameras", "data": cameras}, nil
+}
+
+func (registry *CameraRegistry) GetCamera(id string) (map[string]interface{}, error) {
+	camera, ok := registry.camera(id)
+	if !ok {
+		err := fmt.Errorf("%w: camera %s", ErrNotFound, id)
+		return map[string]interface{}{"code": errorCode(err), "message": "Camera not found", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Camera", "data": camera.status()}, nil
+}
+
+// PutCamera adds or replaces a camera, a replaced camera is connected again on the next request.
+// An empty password keeps the stored password
+func (registry *CameraRegistry) PutCamera(config CameraConfig) (map[string]interface{}, error) {
+	if err := validateCamera(config); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": err.Error(), "data": nil}, err
+	}
+
+	if config.Port == 0 {
+		config.Port = 80
+	}
+	if config.Name == "" {
+		config.Name = config.Id
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if old, ok := registry.cameras[config.Id]; ok && config.Password == "" {
+		config.Password = old.config.Password
+	}
+
+	if err := registry.secrets.Put(config.Id, config.Password); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot save camera password", "data": nil}, err
+	}
+
+	if err := registry.store.Put(bucketCameras, config.Id, config); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot save camera", "data": nil}, err
+	}
+
+	camera := newCamera(config)
+	registry.cameras[config.Id] = camera
+
+	return map[string]interface{}{"code": 200, "message": "Save camera", "data": camera.status()}, nil
+}
+
+func (registry *CameraRegistry) RemoveCamera(id string) (map[string]interface{}, error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, ok := registry.cameras[id]; !ok {
+		err := fmt.Errorf("%w: camera %s", ErrNotFound, id)
+		return map[string]interface{}{"code": errorCode(err), "message": "Camera not found", "data": nil}, err
+	}
+
+	if err := registry.store.Delete(bucketCameras, id); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot remove camera", "data": nil}, err
+	}
+
+	if err := registry.secrets.Delete(id); err != nil {
+		logWarn("Cannot remove password of camera " + id + ":", err)
+	}
+
+	delete(registry.cameras, id)
+
+	return map[string]interface{}{"code": 200, "message": "Remove camera", "data": nil}, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const faultResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope" xmlns:ter="http://www.onvif.org/ver10/error">
+<env:Body><env:Fault>
+<env:Code><env:Value>env:Sender</env:Value><env:Subcode><env:Value>ter:InvalidArgVal</env:Value></env:Subcode></env:Code>
+<env:Reason><env:Text xml:lang="en">Invalid token</env:Text></env:Reason>
+</env:Fault></env:Body>
+</env:Envelope>`
+
+// Port of 127.0.0.1 without listener
+func closedPort(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	return port
+}
+
+func TestUnreachable(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx context.Context
+		err error
+		unreachable bool
+	}{
+		{"answer", context.Background(), nil, false},
+		{"fault", context.Background(), &SOAPFault{Subcodes: []string{"ter:InvalidArgVal"}}, false},
+		{"http error", context.Background(), &HTTPError{StatusCode: 500}, false},
+		{"timeout", context.Background(), fmt.Errorf("post: %w", context.DeadlineExceeded), true},
+		{"refused", context.Background(), &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"canceled request", canceled, context.Canceled, false},
+	}
+
+	for _, test := range tests {
+		if unreachable(test.ctx, test.err) != test.unreachable {
+			t.Errorf("%s: unreachable %v", test.name, !test.unreachable)
+		}
+	}
+}
+
+func TestCameraReconnect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(faultResponse))
+	}))
+
+	dev := &onvifDevice{client: &http.Client{Timeout: time.Second}, state: new(sync.Mutex)}
+	ptz := &PTZControl{cam: dev, connected: true, lock: new(sync.RWMutex)}
+
+	camera := newCamera(CameraConfig{Id: "lost", Ip: "127.0.0.1", Port: uint16(closedPort(t))})
+	camera.ptz = ptz
+
+	// a camera which answers with a fault is connected
+	if _, err := dev.post(context.Background(), server.URL, gotoHomeRequest{ProfileToken: "t"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("fault: %v", err)
+	}
+	if !ptz.Connected() || !camera.status().Connected {
+		t.Fatal("camera answering with a fault is disconnected")
+	}
+	if current, err := camera.connect(context.Background()); current != ptz || err != nil {
+		t.Fatalf("connected camera is connected again: %v", err)
+	}
+
+	// the camera stops answering
+	server.Close()
+	if _, err := dev.post(context.Background(), server.URL, gotoHomeRequest{ProfileToken: "t"}); err == nil {
+		t.Fatal("post to a closed server")
+	}
+	if ptz.Connected() || camera.status().Connected {
+		t.Fatal("camera without answer is connected")
+	}
+
+	// the next request connects again instead of returning the dead control
+	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+	if current, err := camera.connect(ctx); current == ptz || err == nil {
+		t.Errorf("lost camera not connected again: %v", err)
+	}
+}
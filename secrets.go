@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Environment variable of the secrets key, it overrides the key file
+const secretsKeyEnv = "PTZ_SECRETS_KEY"
+
+// AES-256 key
+const secretsKeySize = 32
+
+type SecretsConfig struct {
+	Path string `yaml:"path"`
+	// base64 key, created with a random key if it does not exist
+	KeyFile string `yaml:"key_file"`
+}
+
+// Secrets keeps the camera passwords by camera id in a file encrypted with AES-GCM,
+// it is memory only without path
+type Secrets struct {
+	path string
+	aead cipher.AEAD
+	passwords map[string]string
+	lock *sync.RWMutex
+}
+
+// Secrets of the server, memory only until the secrets of the config file are opened
+var gSecrets = NewMemorySecrets()
+
+// Internal
+
+func loadSecretsKey(path string) ([]byte, error) {
+	encoded := os.Getenv(secretsKeyEnv)
+
+	if encoded == "" {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			key := make([]byte, secretsKeySize)
+			if _, err := io.ReadFull(rand.Reader, key); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key) + "\n"), 0600); err != nil {
+				return nil, err
+			}
+			fmt.Println("Secrets key created: " + path)
+			return key, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(data)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != secretsKeySize {
+		return nil, fmt.Errorf("%w: secrets key must be %d bytes in base64", ErrInvalidArgument, secretsKeySize)
+	}
+
+	return key, nil
+}
+
+// The file is the nonce followed by the sealed JSON of the passwords
+func (secrets *Secrets) load() error {
+	data, err := os.ReadFile(secrets.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	size := secrets.aead.NonceSize()
+	if len(data) < size {
+		return fmt.Errorf("%w: secrets file is too short", ErrInvalidArgument)
+	}
+
+	plain, err := secrets.aead.Open(nil, data[:size], data[size:], nil)
+	if err != nil {
+		return fmt.Errorf("cannot decrypt %s, wrong key: %w", secrets.path, err)
+	}
+
+	return json.Unmarshal(plain, &secrets.passwords)
+}
+
+func (secrets *Secrets) save() error {
+	if secrets.path == "" {
+		return nil
+	}
+
+	plain, err := json.Marshal(secrets.passwords)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, secrets.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(secrets.path, secrets.aead.Seal(nonce, nonce, plain, nil))
+}
+
+// Interface for Outside
+
+// OpenSecrets opens the secrets of the config, the default is secrets.enc with the key
+// of the environment or of secrets.key
+func OpenSecrets(config SecretsConfig) (*Secrets, error) {
+	path, keyFile := config.Path, config.KeyFile
+	if path == "" {
+		path = "secrets.enc"
+	}
+	if keyFile == "" {
+		keyFile = "secrets.key"
+	}
+
+	key, err := loadSecretsKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets := &Secrets{path: path, aead: aead, passwords: make(map[string]string), lock: new(sync.RWMutex)}
+	if err := secrets.load(); err != nil {
+		return nil, err
+	}
+
+	return secrets, nil
+}
+
+// NewMemorySecrets is a secrets store without persistence
+func NewMemorySecrets() *Secrets {
+	return &Secrets{passwords: make(map[string]string), lock: new(sync.RWMutex)}
+}
+
+// Get returns the password of the camera id, empty if it has none
+func (secrets *Secrets) Get(id string) string {
+	secrets.lock.RLock()
+	defer secrets.lock.RUnlock()
+
+	return secrets.passwords[id]
+}
+
+func (secrets *Secrets) Put(id string, password string) error {
+	secrets.lock.Lock()
+	defer secrets.lock.Unlock()
+
+	if secrets.passwords[id] == password {
+		return nil
+	}
+	secrets.passwords[id] = password
+
+	return secrets.save()
+}
+
+func (secrets *Secrets) Delete(id string) error {
+	secrets.lock.Lock()
+	defer secrets.lock.Unlock()
+
+	if _, ok := secrets.passwords[id]; !ok {
+		return nil
+	}
+	delete(secrets.passwords, id)
+
+	return secrets.save()
+}
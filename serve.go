@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Server config of the config file, the environment and the command line
+var gServer = defaultServerConfig()
+
+var logLevels = map[string]int{LogDebug: 0, LogInfo: 1, LogWarn: 2, LogError: 3}
+
+// certReloader loads the TLS certificate again when its files are modified
+type certReloader struct {
+	certFile string
+	keyFile string
+	cert *tls.Certificate
+	modTime time.Time
+	lock *sync.Mutex
+}
+
+// Internal
+
+func logAt(level string, a ...interface{}) {
+	if logLevels[level] >= logLevels[gServer.LogLevel] {
+		fmt.Println(a...)
+	}
+}
+
+func logDebug(a ...interface{}) {
+	logAt(LogDebug, a...)
+}
+
+func logInfo(a ...interface{}) {
+	logAt(LogInfo, a...)
+}
+
+func logWarn(a ...interface{}) {
+	logAt(LogWarn, a...)
+}
+
+func logError(a ...interface{}) {
+	logAt(LogError, a...)
+}
+
+// Latest modification of the certificate and key files
+func (reloader *certReloader) modified() (time.Time, error) {
+	var latest time.Time
+
+	for _, name := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+func (reloader *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.lock.Lock()
+	defer reloader.lock.Unlock()
+
+	modTime, err := reloader.modified()
+	if err != nil || !modTime.After(reloader.modTime) {
+		// keep the loaded certificate while the files are replaced
+		return reloader.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		logWarn("Cannot reload TLS certificate:", err)
+		return reloader.cert, nil
+	}
+
+	logInfo("TLS certificate reloaded: " + reloader.certFile)
+	reloader.cert, reloader.modTime = &cert, modTime
+
+	return reloader.cert, nil
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile, lock: new(sync.Mutex)}
+
+	modTime, err := reloader.modified()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	reloader.cert, reloader.modTime = &cert, modTime
+
+	return reloader, nil
+}
+
+// serve handler with the listen address and TLS of config
+func serve(handler http.Handler, config ServerConfig) error {
+	server := &http.Server{Addr: config.Listen, Handler: handler}
+
+	if config.TLSCert == "" {
+		fmt.Println("Starting Restful server on " + config.Listen + ".")
+		return server.ListenAndServe()
+	}
+
+	if config.TLSReload {
+		reloader, err := newCertReloader(config.TLSCert, config.TLSKey)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+
+		fmt.Println("Starting Restful server on " + config.Listen + " with TLS.")
+		return server.ListenAndServeTLS("", "")
+	}
+
+	fmt.Println("Starting Restful server on " + config.Listen + " with TLS.")
+	return server.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+}
@@ -1,16 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 	"bytes"
-	"strings"
 	"strconv"
-	"log"
-	"image"
+	"strings"
 	"image/jpeg"
-	// "errors"
+	"errors"
 	"sync"
+	"net/url"
   "encoding/base64"
 	"github.com/google/uuid"
 	"github.com/bluenviron/gortsplib/v4"
@@ -23,39 +23,196 @@ import (
 	"github.com/pion/rtp"
 )
 
+// Delays between the reconnects of a failed stream, doubled after each failure
+const (
+	streamBackoffMin = 1 * time.Second
+	streamBackoffMax = 60 * time.Second
+)
+
+// StreamStatus is the health of the video stream of a session
+type StreamStatus struct {
+	Connected bool
+	Reconnects int
+	LastError string
+	LastErrorTime *time.Time `json:",omitempty"`
+}
+
 type Session struct {
 	id string
 	ptz *PTZControl
-  last_time time.Time
-	session_end bool
-	stop_video bool
-	video_stopped bool
-	image image.Image
+	imaging *ImagingControl
+	tour *TourEngine
+	last_time time.Time
+	stream_status StreamStatus
+	// last_time and stream_status lock
+	state *sync.Mutex
+	// lifetime of the session, canceled by Close
+	ctx context.Context
+	cancel context.CancelFunc
+	// stream lock, the stream is stopped with stream_cancel and ends with stream_done
+	stream *sync.Mutex
+	stream_cancel context.CancelFunc
+	stream_done chan struct{}
+	// last frame, frame_ready is closed by the next frame
+	frame *Frame
+	frame_ready chan struct{}
+	// frame lock
 	lock *sync.RWMutex
+	events *EventSubscription
+	events_lock *sync.Mutex
 }
 
-const gTimeout = 30
-
 // Internal threads
 
-// Check session timeout
-func checkSession(session *Session) {
+// Start streaming uri until the session is closed, the stream lock is held
+func (session *Session) startStream(uri string) {
+	ctx, cancel := context.WithCancel(session.ctx)
+	done := make(chan struct{})
+	session.stream_cancel, session.stream_done = cancel, done
+
+	go func() {
+		defer close(done)
+		streamWorker(ctx, uri, session)
+	}()
+}
+
+// Stop the stream and wait for its end until ctx is done, the stream lock is held.
+// A stream which did not stop is waited for again by the next stop
+func (session *Session) stopStream(ctx context.Context) error {
+	if session.stream_cancel == nil {
+		return nil
+	}
+
+	session.stream_cancel()
+
+	select {
+	case <-session.stream_done:
+	case <-ctx.Done():
+		return fmt.Errorf("stream of session %s did not stop: %w", session.id, ctx.Err())
+	}
+
+	session.stream_cancel, session.stream_done = nil, nil
+
+	session.state.Lock()
+	session.stream_status.Connected = false
+	session.state.Unlock()
+
+	return nil
+}
+
+func (session *Session) streamConnected() {
+	session.state.Lock()
+	defer session.state.Unlock()
+
+	session.stream_status.Connected = true
+}
+
+func (session *Session) streamFailed(err error) {
+	now := time.Now()
+
+	session.state.Lock()
+	defer session.state.Unlock()
+
+	session.stream_status.Connected = false
+	session.stream_status.LastError = err.Error()
+	session.stream_status.LastErrorTime = &now
+}
+
+func (session *Session) streamReconnect() {
+	session.state.Lock()
+	defer session.state.Unlock()
+
+	session.stream_status.Reconnects++
+}
+
+// Play the stream once, a panic while the stream is set up is returned as error.
+// The RTP callbacks run on the goroutine of the client, they recover with recoverDecoder
+func playStream(ctx context.Context, uri string, session *Session) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("stream panic: %v", r)
+		}
+	}()
+
+	return processStream(ctx, uri, session)
+}
+
+// Play the stream until ctx is canceled, it is reconnected with exponential backoff after errors
+func streamWorker(ctx context.Context, uri string, session *Session) {
+	backoff := streamBackoffMin
+
 	for {
-		now := time.Now()
+		started := time.Now()
+		err := playStream(ctx, uri, session)
 
-		if session.last_time.Add(time.Second * gTimeout).Before(now) {
-			// fmt.Println("timeout")
-			session.stop_video = true
-			break
+		if ctx.Err() != nil {
+			return
 		}
-		time.Sleep(1 * time.Second)
+
+		if err == nil {
+			err = fmt.Errorf("stream ended")
+		}
+		decodable := !errors.Is(err, ErrUnsupported)
+		// the password of the client is not logged or returned
+		err = redactPassword(err, session.ptz.info.Password)
+		session.streamFailed(err)
+
+		// a stream which cannot be decoded is not retried
+		if !decodable {
+			logWarn("Stream of session " + session.id + " stopped:", err)
+			return
+		}
+
+		// a stream which played for a while starts again with the shortest delay
+		if time.Since(started) > streamBackoffMax {
+			backoff = streamBackoffMin
+		}
+
+		logWarn("Stream error of session " + session.id + ", reconnect in " + backoff.String() + ":", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > streamBackoffMax {
+			backoff = streamBackoffMax
+		}
+
+		session.streamReconnect()
+	}
+}
+
+// Error text without the password, the escaped forms of URLs are replaced first
+func redactPassword(err error, password string) error {
+	if password == "" {
+		return err
 	}
 
-	fmt.Println("Session timeout: " + session.id)
-	session.session_end = true
+	text := err.Error()
+	userinfo := strings.TrimPrefix(url.UserPassword("", password).String(), ":")
+	for _, form := range []string{userinfo, url.QueryEscape(password), url.PathEscape(password), password} {
+		text = strings.ReplaceAll(text, form, "***")
+	}
+
+	return fmt.Errorf("%s", text)
 }
 
-func processStream(uri string, session *Session) {
+// A panic of the decoders in a RTP callback ends the stream with an error
+func recoverDecoder(failed chan<- error) {
+	if r := recover(); r != nil {
+		select {
+		case failed <- fmt.Errorf("stream panic: %v", r):
+		default:
+		}
+	}
+}
+
+func processStream(ctx context.Context, uri string, session *Session) error {
 	// test loop
 	// for {
 	// 	if session.stop_video {
@@ -70,27 +227,47 @@ func processStream(uri string, session *Session) {
 	is_h264 := true
 	c := gortsplib.Client{}
 
-	fmt.Println(uri)
+	logDebug(uri)
 	
 	// parse URL
 	u, err := base.ParseURL(uri)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	// credentials of the client authentication, they are not part of the logged uri
+	info := session.ptz.info
+	if info.Username != "" {
+		u.User = url.UserPassword(info.Username, info.Password)
 	}
 
 	// connect to the server
 	err = c.Start(u.Scheme, u.Host)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer c.Close()
 
+	// Close interrupts Describe, Setup and Play of a canceled stream
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-finished:
+		}
+	}()
+
 	// find available medias
 	desc, _, err := c.Describe(u)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
+	// the first fatal error of the client or panic of the callbacks
+	failed := make(chan error, 1)
+
 	var forma264 *format.H264
 	var forma265 *format.H265
 
@@ -100,7 +277,7 @@ func processStream(uri string, session *Session) {
 		// find the H265 media and format
 		medi = desc.FindFormat(&forma265)
 		if medi == nil {
-			panic("media not found")
+			return fmt.Errorf("%w: no H264 or H265 media", ErrUnsupported)
 		}
 		is_h264 = false
 	}
@@ -109,14 +286,14 @@ func processStream(uri string, session *Session) {
 	if is_h264 {
 		rtpDec, err := forma264.CreateDecoder()
 		if err != nil {
-			panic(err)
+			return err
 		}
 
 		// setup H264 -> raw frames decoder
 		frameDec := &h264Decoder{}
 		err = frameDec.initialize()
 		if err != nil {
-			panic(err)
+			return err
 		}
 		defer frameDec.close()
 
@@ -131,18 +308,20 @@ func processStream(uri string, session *Session) {
 		// setup a single media
 		_, err = c.Setup(desc.BaseURL, medi, 0, 0)
 		if err != nil {
-			panic(err)
+			return err
 		}
 
 		iframeReceived := false
 
 		// called when a RTP packet arrives
 		c.OnPacketRTP(medi, forma264, func(pkt *rtp.Packet) {
+			defer recoverDecoder(failed)
+
 			// extract access units from RTP packets
 			au, err := rtpDec.Decode(pkt)
 			if err != nil {
 				if err != rtph264.ErrNonStartingPacketAndNoPrevious && err != rtph264.ErrMorePacketsNeeded {
-					log.Printf("ERR: %v", err)
+					logError("ERR:", err)
 				}
 				return
 			}
@@ -150,7 +329,7 @@ func processStream(uri string, session *Session) {
 			// wait for an I-frame
 			if !iframeReceived {
 				if !h264.IDRPresent(au) {
-					log.Printf("waiting for an I-frame")
+					logDebug("waiting for an I-frame")
 					return
 				}
 				iframeReceived = true
@@ -160,7 +339,7 @@ func processStream(uri string, session *Session) {
 				// convert NALUs into RGBA frames
 				img, _ := frameDec.decode(nalu)
 				// if err != nil {
-				// 	panic(err)
+				// 	return err
 				// }
 
 				// wait for a frame
@@ -168,21 +347,21 @@ func processStream(uri string, session *Session) {
 					continue
 				}
 
-				session.image = img
+				session.setFrame(img)
 			}
 		})
 	} else {
 		// setup RTP/H265 -> H265 decoder
 		rtpDec, err := forma265.CreateDecoder()
 		if err != nil {
-			panic(err)
+			return err
 		}
 
 		// setup H265 -> raw frames decoder
 		frameDec := &h265Decoder{}
 		err = frameDec.initialize()
 		if err != nil {
-			panic(err)
+			return err
 		}
 		defer frameDec.close()
 
@@ -200,18 +379,20 @@ func processStream(uri string, session *Session) {
 		// setup a single media
 		_, err = c.Setup(desc.BaseURL, medi, 0, 0)
 		if err != nil {
-			panic(err)
+			return err
 		}
 
 		iframeReceived := false
 
 		// called when a RTP packet arrives
 		c.OnPacketRTP(medi, forma265, func(pkt *rtp.Packet) {
+			defer recoverDecoder(failed)
+
 			// extract access units from RTP packets
 			au, err := rtpDec.Decode(pkt)
 			if err != nil {
 				if err != rtph265.ErrNonStartingPacketAndNoPrevious && err != rtph265.ErrMorePacketsNeeded {
-					log.Printf("ERR: %v", err)
+					logError("ERR:", err)
 				}
 				return
 			}
@@ -219,7 +400,7 @@ func processStream(uri string, session *Session) {
 			// wait for an I-frame
 			if !iframeReceived {
 				if !h265.IsRandomAccess(au) {
-					log.Printf("waiting for an I-frame")
+					logDebug("waiting for an I-frame")
 					return
 				}
 				iframeReceived = true
@@ -229,7 +410,8 @@ func processStream(uri string, session *Session) {
 				// convert NALUs into RGBA frames
 				img, err := frameDec.decode(nalu)
 				if err != nil {
-					panic(err)
+					logError("ERR:", err)
+					return
 				}
 
 				// wait for a frame
@@ -237,9 +419,7 @@ func processStream(uri string, session *Session) {
 					continue
 				}
 
-				session.lock.Lock()
-				session.image = img
-				session.lock.Unlock()
+				session.setFrame(img)
 			}
 		})
 	}
@@ -247,21 +427,30 @@ func processStream(uri string, session *Session) {
 	// start playing
 	_, err = c.Play(nil)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	for {
-		if session.stop_video {
-			fmt.Println("Streaming End: " + session.ptz.info.Ip + ":" + strconv.Itoa(int(session.ptz.info.Port)))
-			break
+	session.streamConnected()
+
+	// wait until a fatal error
+	go func() {
+		err := c.Wait()
+		select {
+		case failed <- err:
+		default:
 		}
-		time.Sleep(50 * time.Millisecond)
+	}()
+
+	select {
+	case <-ctx.Done():
+		logInfo("Streaming End: " + session.ptz.info.Ip + ":" + strconv.Itoa(int(session.ptz.info.Port)))
+	case err = <-failed:
 	}
 
-	session.video_stopped = true
+	// the callbacks stop before the decoders are closed
+	c.Close()
 
-	// wait until a fatal error
-	// panic(c.Wait())
+	return err
 }
 
 // get video stream and decode to Image
@@ -279,101 +468,221 @@ func processStream(uri string, session *Session) {
 // 	session.session_end = true
 // }
 
-func NewSession(ip string, port uint16, username string, password string) (*Session, error) {
-	ptz, err := NewPTZControl(ip, port, username, password)
-	if err != nil {
-		fmt.Println("init session error:", err)
-		return &Session{}, err
+// Session of ptz without stream
+func newSession(ptz *PTZControl) *Session {
+	session := &Session{
+		id: uuid.New().String(),
+		ptz: ptz,
+		imaging: NewImagingControl(ptz),
+		tour: NewTourEngine(ptz, gStore),
+		last_time: time.Now(),
+		state: new(sync.Mutex),
+		stream: new(sync.Mutex),
+		frame_ready: make(chan struct{}),
+		lock: new(sync.RWMutex),
+		events_lock: new(sync.Mutex),
 	}
 
-	res, _ := ptz.GetStreamUri()
+	// the session outlives the request which started it
+	session.ctx, session.cancel = context.WithCancel(context.Background())
 
-	rtsp_uri := res["data"].(PTZUri).Uri
+	return session
+}
 
-	rtsp_uri = strings.Replace(rtsp_uri, "rtsp://", "rtsp://"+ username + ":"+ password + "@", 1)
+func NewSession(ctx context.Context, ip string, port uint16, username string, password string) (*Session, error) {
+	ptz, err := NewPTZControl(ctx, ip, port, username, password)
+	if err != nil {
+		logError("init session error:", err)
+		return &Session{}, err
+	}
 
-	uuid := uuid.New()
+	return NewCameraSession(ctx, ptz)
+}
 
-	session := Session{
-		id: uuid.String(),
-		ptz: ptz,
-		last_time: time.Now(),
-		session_end: false,
-		stop_video: false,
-		video_stopped: false,
-		image: nil,
-		lock: new(sync.RWMutex),
+// NewCameraSession starts a session of a connected camera, e.g. a camera of the registry
+func NewCameraSession(ctx context.Context, ptz *PTZControl) (*Session, error) {
+	res, err := ptz.GetStreamUri(ctx)
+	if err != nil {
+		logError("init session error:", err)
+		return &Session{}, err
 	}
 
-	// Start video streaming thread
-	go processStream(rtsp_uri, &session)
+	rtsp_uri := res["data"].(PTZUri).Uri
 
-	// Start session timeout checking thread
-	go checkSession(&session)
+	session := newSession(ptz)
 
-	fmt.Println("Session start: " + session.id + " - " + session.ptz.info.Ip + ":" + strconv.Itoa(int(session.ptz.info.Port)))
+	// Start video streaming thread
+	session.stream.Lock()
+	session.startStream(rtsp_uri)
+	session.stream.Unlock()
 
-	return &session, nil
+	logInfo("Session start: " + session.id + " - " + session.ptz.info.Ip + ":" + strconv.Itoa(int(session.ptz.info.Port)))
+
+	return session, nil
 }
 
 // Interface
 
 func (session *Session) ActivateSession() {
+	session.state.Lock()
+	defer session.state.Unlock()
+
 	session.last_time = time.Now()
 }
 
-func (session *Session) ChangeProfile(profile string) error {
-	// fmt.Println("Change profile: " + profile)
-	session.ptz.SetProfile(profile)
+// Expired is true after the session timeout without request, a running tour keeps the session alive
+func (session *Session) Expired() bool {
+	// a running tour keeps the session, a paused one expires with the normal timeout
+	if session.tour.Running() {
+		session.ActivateSession()
+		return false
+	}
+
+	session.state.Lock()
+	defer session.state.Unlock()
+
+	return session.last_time.Add(gServer.Timeout()).Before(time.Now())
+}
+
+// StreamStatus returns the health of the video stream
+func (session *Session) StreamStatus() map[string]interface{} {
+	session.state.Lock()
+	status := session.stream_status
+	session.state.Unlock()
+
+	return map[string]interface{}{"code": 200, "message": "Stream status", "data": status}
+}
+
+// StreamConnected reports whether the stream is playing
+func (session *Session) StreamConnected() bool {
+	session.state.Lock()
+	defer session.state.Unlock()
+
+	return session.stream_status.Connected
+}
+
+// Pause the tour of the session on the moves requested through the interface, the control
+// of a registry camera is shared by its sessions and is bound to the tour of the last one
+func (session *Session) bindTour() {
+	session.ptz.setOnMove(session.tour.yield)
+}
+
+// Done is closed when the session is closed
+func (session *Session) Done() <-chan struct{} {
+	return session.ctx.Done()
+}
+
+// Close stops the stream, the tour and the event subscription of the session, it returns
+// an error if the stream does not stop until ctx is done. Close may be called again
+func (session *Session) Close(ctx context.Context) error {
+	session.cancel()
+
+	session.stream.Lock()
+	err := session.stopStream(ctx)
+	session.stream.Unlock()
+
+	session.tour.Stop()
 
-	//Stop video stream
-	session.stop_video = true
+	session.events_lock.Lock()
+	if session.events != nil {
+		session.events.Close(ctx)
+		session.events = nil
+	}
+	session.events_lock.Unlock()
+
+	session.lock.Lock()
+	session.frame = nil
+	session.lock.Unlock()
+
+	return err
+}
 
-	res, err := session.ptz.GetStreamUri()
+// ChangeProfile restarts the stream with profile, the profile is changed after the old stream
+// stopped. It returns an error if the stream does not stop until ctx is done
+func (session *Session) ChangeProfile(ctx context.Context, profile string) error {
+	rtsp_uri, err := session.ptz.StreamUri(ctx, profile)
 
 	if err != nil {
 		return err
 	}
 
-	// Wait for video streaming stop
-	for {
-		if session.video_stopped {
-			break
-		}
-		time.Sleep(50 * time.Millisecond)
+	session.stream.Lock()
+	defer session.stream.Unlock()
+
+	if session.ctx.Err() != nil {
+		return fmt.Errorf("%w: session %s is closed", ErrNotFound, session.id)
 	}
 
-	rtsp_uri := res["data"].(PTZUri).Uri
+	// Restart video streaming thread, the old stream is kept if it does not stop
+	if err := session.stopStream(ctx); err != nil {
+		return err
+	}
+	if err := session.ptz.SetProfile(profile); err != nil {
+		return err
+	}
+	session.startStream(rtsp_uri)
 
-	rtsp_uri = strings.Replace(rtsp_uri, "rtsp://", "rtsp://"+ session.ptz.info.Username + ":"+ session.ptz.info.Password + "@", 1)
+	return nil
+}
 
-	session.stop_video = false
-	session.video_stopped = false
+// SubscribeEvents returns a channel receiving the events of the camera until UnsubscribeEvents,
+// the subscription of the camera is created with the first client
+func (session *Session) SubscribeEvents(ctx context.Context) (chan Event, error) {
+	session.events_lock.Lock()
+	defer session.events_lock.Unlock()
 
-	// Start video streaming thread
-	go processStream(rtsp_uri, session)
+	if session.events == nil {
+		events, err := NewEventSubscription(ctx, session.ptz)
+		if err != nil {
+			return nil, err
+		}
+		session.events = events
+	}
 
-	return nil
+	return session.events.Subscribe(), nil
 }
 
-func (session *Session) GetSnapshot() map[string]interface{} {
+// UnsubscribeEvents removes the client, the camera is not pulled any more after the last client
+func (session *Session) UnsubscribeEvents(client chan Event) {
+	session.events_lock.Lock()
+	events := session.events
+	if events == nil {
+		session.events_lock.Unlock()
+		return
+	}
+	events.Unsubscribe(client)
+	if events.Clients() > 0 {
+		session.events_lock.Unlock()
+		return
+	}
+	session.events = nil
+	session.events_lock.Unlock()
+
+	// the request of the client is done, the removal is bounded by callTimeout
+	if err := events.Close(context.Background()); err != nil {
+		logWarn("Cannot remove event subscription of session " + session.id + ":", err)
+	}
+}
+
+func (session *Session) GetSnapshot(ctx context.Context) map[string]interface{} {
 	// fmt.Println("Snapshot")
-	session.lock.RLock()
+	snapshot, err := SessionSnapshot(ctx, session, SnapshotAuto, false)
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "No frame received", "data": nil}
+	}
 
-	if session.image == nil {
-		session.lock.RUnlock()
-		return map[string]interface{}{"code": 500, "message": "No frame received", "data": nil}
+	img, err := snapshot.Decode()
+	if err != nil {
+		return map[string]interface{}{"code": 502, "message": "Cannot decode snapshot", "data": nil}
 	}
 
 	var buf bytes.Buffer
-	size := session.image.Bounds().Size()
+	size := img.Bounds().Size()
 
-	err := jpeg.Encode(&buf, session.image, &jpeg.Options{
+	err = jpeg.Encode(&buf, img, &jpeg.Options{
 		Quality: 80,
 	})
 
-	session.lock.RUnlock()
-
 	if err != nil {
 		return map[string]interface{}{"code": 500, "message": "No frame received", "data": nil}
 	}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SessionManager keeps one session per camera, shared by the clients connected to the
+// camera. A session is closed when its last client disconnects or when it expires
+type SessionManager struct {
+	sessions map[string]*Session
+	// session id by camera ip:port
+	cameras map[string]string
+	// connected clients by session id
+	refs map[string]int
+	lock *sync.Mutex
+}
+
+// Maximum time to stop the stream of a closed session
+const sessionCloseTimeout = 10 * time.Second
+
+// Sessions of the cookies
+var gSessions = NewSessionManager()
+
+// Internal
+
+func cameraKey(ip string, port uint16) string {
+	return fmt.Sprintf("%s:%d", ip, port)
+}
+
+// Remove the session, the lock is held and the session is closed by the caller
+func (manager *SessionManager) remove(session *Session) {
+	delete(manager.sessions, session.id)
+	delete(manager.refs, session.id)
+
+	key := cameraKey(session.ptz.info.Ip, session.ptz.info.Port)
+	if manager.cameras[key] == session.id {
+		delete(manager.cameras, key)
+	}
+}
+
+// Close the session, the lock is not held
+func closeSession(session *Session) {
+	ctx, cancel := context.WithTimeout(context.Background(), sessionCloseTimeout)
+	defer cancel()
+
+	if err := session.Close(ctx); err != nil {
+		logWarn("Cannot close session " + session.id + ":", err)
+	}
+}
+
+// Interface for Outside
+
+func NewSessionManager() *SessionManager {
+	return &SessionManager{
+		sessions: make(map[string]*Session),
+		cameras: make(map[string]string),
+		refs: make(map[string]int),
+		lock: new(sync.Mutex),
+	}
+}
+
+// Get returns the session of id, ErrNotFound if it is closed or unknown
+func (manager *SessionManager) Get(id string) (*Session, error) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	session, ok := manager.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: session %s", ErrNotFound, id)
+	}
+
+	return session, nil
+}
+
+// Acquire adds a client to the session of the camera, the session is started with start
+// if the camera has none
+func (manager *SessionManager) Acquire(ip string, port uint16, start func() (*Session, error)) (*Session, error) {
+	key := cameraKey(ip, port)
+
+	manager.lock.Lock()
+	if id, ok := manager.cameras[key]; ok {
+		manager.refs[id]++
+		session := manager.sessions[id]
+		manager.lock.Unlock()
+
+		logInfo("Session exist: " + id)
+		return session, nil
+	}
+	manager.lock.Unlock()
+
+	// the camera is not locked while connecting, which may take the whole call timeout
+	session, err := start()
+	if err != nil {
+		return nil, err
+	}
+
+	manager.lock.Lock()
+	if id, ok := manager.cameras[key]; ok {
+		// started by another client meanwhile
+		manager.refs[id]++
+		existing := manager.sessions[id]
+		manager.lock.Unlock()
+
+		closeSession(session)
+		return existing, nil
+	}
+
+	manager.sessions[session.id] = session
+	manager.cameras[key] = session.id
+	manager.refs[session.id] = 1
+	// only the session which is kept pauses its tour on manual moves
+	session.bindTour()
+	manager.lock.Unlock()
+
+	return session, nil
+}
+
+// Release removes a client of the session, the session is closed with its last client
+func (manager *SessionManager) Release(id string) error {
+	manager.lock.Lock()
+
+	session, ok := manager.sessions[id]
+	if !ok {
+		manager.lock.Unlock()
+		return fmt.Errorf("%w: session %s", ErrNotFound, id)
+	}
+
+	manager.refs[id]--
+	if manager.refs[id] > 0 {
+		manager.lock.Unlock()
+		return nil
+	}
+
+	manager.remove(session)
+	manager.lock.Unlock()
+
+	closeSession(session)
+	logInfo("Session closed: " + id)
+
+	return nil
+}
+
+// CloseAll closes all sessions, e.g. on shutdown
+func (manager *SessionManager) CloseAll() {
+	manager.lock.Lock()
+	sessions := make([]*Session, 0, len(manager.sessions))
+	for _, session := range manager.sessions {
+		sessions = append(sessions, session)
+		manager.remove(session)
+	}
+	manager.lock.Unlock()
+
+	for _, session := range sessions {
+		closeSession(session)
+	}
+}
+
+// Expire closes the expired sessions
+func (manager *SessionManager) Expire() {
+	manager.lock.Lock()
+	expired := make([]*Session, 0)
+	for _, session := range manager.sessions {
+		if session.Expired() {
+			expired = append(expired, session)
+			manager.remove(session)
+		}
+	}
+	manager.lock.Unlock()
+
+	for _, session := range expired {
+		closeSession(session)
+		logInfo("Session expired: " + session.id)
+	}
+}
+
+func check_session_expire() {
+	for {
+		gSessions.Expire()
+		time.Sleep(1 * time.Second)
+	}
+}
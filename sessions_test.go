@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Control of a camera which is not connected
+func newTestControl(ip string) *PTZControl {
+	return &PTZControl{info: PTZInfo{Ip: ip, Port: 80}, lock: new(sync.RWMutex)}
+}
+
+func isClosed(session *Session) bool {
+	select {
+	case <-session.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestAcquireConcurrent(t *testing.T) {
+	manager := NewSessionManager()
+	// the control of a registry camera is shared by its sessions
+	ptz := newTestControl("192.0.2.20")
+
+	var started []*Session
+	var lock sync.Mutex
+	start := func() (*Session, error) {
+		session := newSession(ptz)
+		lock.Lock()
+		started = append(started, session)
+		lock.Unlock()
+		return session, nil
+	}
+
+	const clients = 20
+	sessions := make([]*Session, clients)
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			session, err := manager.Acquire("192.0.2.20", 80, start)
+			if err != nil {
+				t.Error(err)
+			}
+			sessions[i] = session
+		}(i)
+	}
+	wg.Wait()
+
+	for _, session := range sessions {
+		if session != sessions[0] {
+			t.Fatal("clients of a camera have different sessions")
+		}
+	}
+	if refs := manager.refs[sessions[0].id]; refs != clients {
+		t.Errorf("refs %d, want %d", refs, clients)
+	}
+	for _, session := range started {
+		if session != sessions[0] && !isClosed(session) {
+			t.Error("session of a losing start is not closed")
+		}
+	}
+
+	// a manual move pauses the tour of the kept session
+	engine := sessions[0].tour
+	engine.lock.Lock()
+	engine.status.State = TourRunning
+	engine.lock.Unlock()
+
+	ptz.moved()
+
+	engine.lock.Lock()
+	state := engine.status.State
+	engine.lock.Unlock()
+	if state != TourPaused {
+		t.Errorf("tour state %v after a move, want paused", state)
+	}
+
+	engine.lock.Lock()
+	engine.status.State = TourStopped
+	engine.lock.Unlock()
+	manager.CloseAll()
+}
+
+func TestRelease(t *testing.T) {
+	manager := NewSessionManager()
+	start := func() (*Session, error) {
+		return newSession(newTestControl("192.0.2.21")), nil
+	}
+
+	session, _ := manager.Acquire("192.0.2.21", 80, start)
+	manager.Acquire("192.0.2.21", 80, start)
+
+	if err := manager.Release(session.id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Get(session.id); err != nil || isClosed(session) {
+		t.Fatal("session closed before its last client")
+	}
+
+	if err := manager.Release(session.id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Get(session.id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a released session: %v", err)
+	}
+	if !isClosed(session) {
+		t.Error("session not closed with its last client")
+	}
+
+	if err := manager.Release(session.id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Release of a closed session: %v", err)
+	}
+
+	// the camera gets a new session
+	next, _ := manager.Acquire("192.0.2.21", 80, start)
+	if next == session {
+		t.Error("closed session reused")
+	}
+	manager.CloseAll()
+}
+
+func TestExpire(t *testing.T) {
+	manager := NewSessionManager()
+
+	expired, _ := manager.Acquire("192.0.2.22", 80, func() (*Session, error) {
+		return newSession(newTestControl("192.0.2.22")), nil
+	})
+	active, _ := manager.Acquire("192.0.2.23", 80, func() (*Session, error) {
+		return newSession(newTestControl("192.0.2.23")), nil
+	})
+
+	expired.state.Lock()
+	expired.last_time = time.Now().Add(-2 * gServer.Timeout())
+	expired.state.Unlock()
+
+	manager.Expire()
+
+	if _, err := manager.Get(expired.id); !errors.Is(err, ErrNotFound) || !isClosed(expired) {
+		t.Error("expired session not closed")
+	}
+	if _, err := manager.Get(active.id); err != nil || isClosed(active) {
+		t.Error("active session closed")
+	}
+	manager.CloseAll()
+}
+
+func TestExpireTour(t *testing.T) {
+	for _, test := range []struct {
+		state string
+		expired bool
+	}{
+		{TourRunning, false},
+		{TourPaused, true},
+		{TourStopped, true},
+	} {
+		session := newSession(newTestControl("192.0.2.34"))
+
+		session.tour.lock.Lock()
+		session.tour.status.State = test.state
+		session.tour.lock.Unlock()
+
+		session.state.Lock()
+		session.last_time = time.Now().Add(-2 * gServer.Timeout())
+		session.state.Unlock()
+
+		if session.Expired() != test.expired {
+			t.Errorf("session with %s tour expired %v", test.state, !test.expired)
+		}
+
+		session.tour.lock.Lock()
+		session.tour.status.State = TourStopped
+		session.tour.lock.Unlock()
+		session.Close(context.Background())
+	}
+}
+
+func TestCloseAll(t *testing.T) {
+	manager := NewSessionManager()
+
+	var sessions []*Session
+	for _, ip := range []string{"192.0.2.24", "192.0.2.25"} {
+		ip := ip
+		session, _ := manager.Acquire(ip, 80, func() (*Session, error) {
+			return newSession(newTestControl(ip)), nil
+		})
+		sessions = append(sessions, session)
+	}
+
+	manager.CloseAll()
+
+	for _, session := range sessions {
+		if _, err := manager.Get(session.id); !errors.Is(err, ErrNotFound) || !isClosed(session) {
+			t.Error("session not closed")
+		}
+	}
+}
+
+func TestSessionsConcurrent(t *testing.T) {
+	manager := NewSessionManager()
+	start := func() (*Session, error) {
+		return newSession(newTestControl("192.0.2.26")), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, err := manager.Acquire("192.0.2.26", 80, start)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			session.ActivateSession()
+			session.ptz.moved()
+			session.ptz.token()
+			manager.Expire()
+			manager.Release(session.id)
+		}()
+	}
+	wg.Wait()
+
+	if len(manager.sessions) != 0 || len(manager.cameras) != 0 || len(manager.refs) != 0 {
+		t.Errorf("sessions left after the last release: %d", len(manager.sessions))
+	}
+}
+
+func TestChangeProfileUnknown(t *testing.T) {
+	ptz := newTestControl("192.0.2.27")
+	ptz.profiles = map[string]string{"main": "token1", "sub": "token2"}
+	ptz.profile_name = "main"
+	session := newSession(ptz)
+
+	err := session.ChangeProfile(context.Background(), "unknown")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("ChangeProfile to an unknown profile: %v", err)
+	}
+	if ptz.Profile() != "main" {
+		t.Errorf("profile changed to %s", ptz.Profile())
+	}
+
+	// the stream URI of a known profile needs the camera
+	err = session.ChangeProfile(context.Background(), "sub")
+	if !errors.Is(err, ErrNotConnected) || ptz.Profile() != "main" {
+		t.Errorf("ChangeProfile without camera: %v, profile %s", err, ptz.Profile())
+	}
+
+	session.Close(context.Background())
+}
+
+func TestStopStreamTimeout(t *testing.T) {
+	session := newSession(newTestControl("192.0.2.28"))
+
+	// a stream which does not stop
+	stuck := make(chan struct{})
+	session.stream_cancel, session.stream_done = func() {}, stuck
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+
+	err := session.Close(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || errorCode(err) != http.StatusGatewayTimeout {
+		t.Fatalf("Close of a stuck stream: %v", err)
+	}
+	if !isClosed(session) {
+		t.Error("session not canceled")
+	}
+
+	// the next stop waits again
+	close(stuck)
+	if err := session.Close(context.Background()); err != nil || session.stream_done != nil {
+		t.Errorf("Close after the stream stopped: %v", err)
+	}
+}
+
+func TestRedactPassword(t *testing.T) {
+	const password = "s3cr@t w/%"
+	u := url.URL{Scheme: "rtsp", Host: "192.0.2.29", User: url.UserPassword("admin", password)}
+
+	for _, text := range []string{
+		"auth failed for " + password,
+		"Describe " + u.String() + ": 401",
+		"query pass=" + url.QueryEscape(password),
+		"path /" + url.PathEscape(password),
+	} {
+		err := redactPassword(errors.New(text), password)
+		if strings.Contains(err.Error(), "3cr") {
+			t.Errorf("password in %q", err)
+		}
+	}
+
+	if err := errors.New("stream ended"); redactPassword(err, "") != err {
+		t.Error("error without password changed")
+	}
+}
+
+func TestRecoverDecoder(t *testing.T) {
+	failed := make(chan error, 1)
+
+	callback := func() {
+		defer recoverDecoder(failed)
+		panic("bad NALU")
+	}
+	callback()
+	// a second panic does not block the client
+	callback()
+
+	if err := <-failed; !strings.Contains(err.Error(), "bad NALU") {
+		t.Errorf("panic error %v", err)
+	}
+}
+
+func TestSessionSnapshotFallback(t *testing.T) {
+	session := newSession(newTestControl("192.0.2.30"))
+	defer session.Close(context.Background())
+
+	// a stream without frames and a camera without connection
+	session.streamConnected()
+
+	start := time.Now()
+	_, err := SessionSnapshot(context.Background(), session, SnapshotAuto, false)
+	if !errors.Is(err, ErrNotConnected) {
+		t.Errorf("auto snapshot: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2 * snapshotFirstFrameTimeout {
+		t.Errorf("auto snapshot waited %v for the stream", elapsed)
+	}
+
+	res := session.GetSnapshot(context.Background())
+	if res["code"] != http.StatusServiceUnavailable {
+		t.Errorf("snapshot without frame and camera: %v", res)
+	}
+
+	// a frame of the stream is returned without camera
+	session.setFrame(image.NewRGBA(image.Rect(0, 0, 4, 2)))
+
+	res = session.GetSnapshot(context.Background())
+	data, _ := res["data"].(map[string]interface{})
+	if res["code"] != 200 || data["w"] != 4 || data["h"] != 2 {
+		t.Errorf("snapshot of a frame: %v", res)
+	}
+
+	// the frame of a reconnecting stream is not returned, the camera is asked
+	session.streamFailed(errors.New("stream ended"))
+
+	if _, err := SessionSnapshot(context.Background(), session, SnapshotAuto, false); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("auto snapshot of a disconnected stream: %v", err)
+	}
+	if snapshot, err := SessionSnapshot(context.Background(), session, SnapshotStream, false); err != nil || snapshot.Seq == 0 {
+		t.Errorf("stream snapshot of a disconnected stream: %v", err)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Sources of the snapshot endpoint
+const (
+	// frame of the stream, the camera if the stream is not playing or has none
+	SnapshotAuto = "auto"
+	SnapshotStream = "stream"
+	// JPEG of the snapshot URI of the camera
+	SnapshotCamera = "camera"
+)
+
+// Maximum size of a snapshot fetched from the camera
+const snapshotMaxBytes = 16 << 20
+
+// Maximum wait for a fresh snapshot frame
+const snapshotWaitTimeout = 5 * time.Second
+
+// Wait of the auto source for the first frame of a stream before the camera is asked
+const snapshotFirstFrameTimeout = 500 * time.Millisecond
+
+// Snapshot is a still image of a stream frame or of the camera
+type Snapshot struct {
+	Image image.Image
+	// encoded image of the camera, sent as is without resize
+	Data []byte
+	ContentType string
+	Time time.Time
+	// frame of the stream, 0 for the camera
+	Seq uint64
+}
+
+// Internal
+
+// Parse the comma separated name=value parameters of a WWW-Authenticate challenge
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+
+	for s = strings.TrimSpace(s); s != ""; {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimSpace(s[eq + 1:])
+
+		var value string
+		if strings.HasPrefix(s, "\"") {
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end > len(s) {
+				end = len(s)
+			}
+			value = strings.ReplaceAll(s[1:end], "\\", "")
+			s = s[end:]
+			s = strings.TrimPrefix(s, "\"")
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+
+		params[name] = value
+		s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), ","))
+	}
+
+	return params
+}
+
+// Authorization header for a Digest challenge (RFC 7616), MD5 and SHA-256 with qop auth
+func digestAuthorization(challenge string, method string, uri string, username string, password string) (string, error) {
+	params := parseAuthParams(challenge)
+
+	algorithm := params["algorithm"]
+	var newHash func() hash.Hash
+	switch strings.ToUpper(strings.TrimSuffix(strings.ToLower(algorithm), "-sess")) {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("%w: digest algorithm %s", ErrUnsupported, algorithm)
+	}
+	digest := func(parts ...string) string {
+		h := newHash()
+		io.WriteString(h, strings.Join(parts, ":"))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	qop := ""
+	if params["qop"] != "" {
+		for _, option := range strings.Split(params["qop"], ",") {
+			if strings.TrimSpace(option) == "auth" {
+				qop = "auth"
+			}
+		}
+		if qop == "" {
+			return "", fmt.Errorf("%w: digest qop %s", ErrUnsupported, params["qop"])
+		}
+	}
+
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(random)
+	nc := "00000001"
+
+	ha1 := digest(username, params["realm"], password)
+	if strings.HasSuffix(strings.ToLower(algorithm), "-sess") {
+		ha1 = digest(ha1, params["nonce"], cnonce)
+	}
+	ha2 := digest(method, uri)
+
+	var response string
+	if qop == "" {
+		response = digest(ha1, params["nonce"], ha2)
+	} else {
+		response = digest(ha1, params["nonce"], nc, cnonce, qop, ha2)
+	}
+
+	header := fmt.Sprintf("Digest username=%q, realm=%q, nonce=%q, uri=%q, response=%q", username, params["realm"], params["nonce"], uri, response)
+	if algorithm != "" {
+		header += ", algorithm=" + algorithm
+	}
+	if qop != "" {
+		header += fmt.Sprintf(", qop=%s, nc=%s, cnonce=%q", qop, nc, cnonce)
+	}
+	if opaque, ok := params["opaque"]; ok {
+		header += fmt.Sprintf(", opaque=%q", opaque)
+	}
+
+	return header, nil
+}
+
+// Authorization header for the challenges of a 401 response, Digest is preferred to Basic
+func authorization(res *http.Response, username string, password string) (string, error) {
+	req := res.Request
+	challenges := res.Header.Values("WWW-Authenticate")
+
+	for _, challenge := range challenges {
+		if len(challenge) > 7 && strings.EqualFold(challenge[:7], "Digest ") {
+			return digestAuthorization(challenge[7:], req.Method, req.URL.RequestURI(), username, password)
+		}
+	}
+
+	for _, challenge := range challenges {
+		if strings.HasPrefix(strings.ToLower(challenge), "basic") {
+			return "Basic " + base64.StdEncoding.EncodeToString([]byte(username + ":" + password)), nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: authentication %s", ErrUnsupported, strings.Join(challenges, ", "))
+}
+
+// fetchSnapshot gets the image of uri, it answers a Digest or Basic challenge with the
+// credentials of the URI or username and password
+func fetchSnapshot(ctx context.Context, client *http.Client, uri string, username string, password string) ([]byte, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+		u.User = nil
+	}
+
+	get := func(auth string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		return client.Do(req)
+	}
+
+	res, err := get("")
+	if err != nil {
+		return nil, "", err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized && username != "" {
+		io.Copy(io.Discard, io.LimitReader(res.Body, snapshotMaxBytes))
+		res.Body.Close()
+
+		auth, err := authorization(res, username, password)
+		if err != nil {
+			return nil, "", err
+		}
+
+		res, err = get(auth)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, "", &HTTPError{StatusCode: res.StatusCode, Status: res.Status}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, snapshotMaxBytes + 1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > snapshotMaxBytes {
+		return nil, "", fmt.Errorf("camera snapshot is larger than %d bytes", snapshotMaxBytes)
+	}
+
+	// cameras often send a wrong content type
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, "", fmt.Errorf("camera snapshot is not an image: %s", contentType)
+	}
+
+	return data, contentType, nil
+}
+
+// Interface for Outside
+
+// CameraSnapshot takes a snapshot with the snapshot URI of the camera
+func CameraSnapshot(ctx context.Context, ptz *PTZControl) (*Snapshot, error) {
+	data, contentType, err := ptz.GetCameraSnapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{Data: data, ContentType: contentType, Time: time.Now()}, nil
+}
+
+// SessionSnapshot takes the snapshot of the session from the stream, the camera or by
+// default from the camera if the stream is not playing or has no frame
+func SessionSnapshot(ctx context.Context, session *Session, source string, fresh bool) (*Snapshot, error) {
+	var snapshot *Snapshot
+	var err error
+
+	// the last frame of a stream which is not playing is stale
+	if source == SnapshotAuto && !session.StreamConnected() {
+		source = SnapshotCamera
+	}
+
+	if source != SnapshotCamera {
+		timeout := snapshotWaitTimeout
+		// a stream without frame may not decode, the camera is asked soon
+		if source == SnapshotAuto && session.Frame() == nil {
+			timeout = snapshotFirstFrameTimeout
+		}
+		snapshot, err = StreamSnapshot(ctx, session, fresh, timeout)
+	}
+
+	if snapshot == nil && source != SnapshotStream {
+		snapshot, err = CameraSnapshot(ctx, session.ptz)
+	}
+
+	return snapshot, err
+}
+
+// StreamSnapshot returns the last frame of the session, or waits until timeout for the next
+// frame if fresh is set or the session has no frame yet
+func StreamSnapshot(ctx context.Context, session *Session, fresh bool, timeout time.Duration) (*Snapshot, error) {
+	frame := session.Frame()
+
+	if fresh || frame == nil {
+		var after uint64
+		if frame != nil {
+			after = frame.Seq
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		var err error
+		frame, err = session.NextFrame(ctx, after)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Snapshot{Image: frame.Image, Time: frame.Time, Seq: frame.Seq}, nil
+}
+
+// Decode returns the image of the snapshot
+func (snapshot *Snapshot) Decode() (image.Image, error) {
+	if snapshot.Image != nil {
+		return snapshot.Image, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(snapshot.Data))
+	return img, err
+}
+
+// Tag identifies the image of the snapshot, the frame of a stream or the hash of a camera image
+func (snapshot *Snapshot) Tag() string {
+	if snapshot.Data == nil {
+		return fmt.Sprintf("%d", snapshot.Seq)
+	}
+
+	h := fnv.New64a()
+	h.Write(snapshot.Data)
+	return fmt.Sprintf("c%x", h.Sum64())
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of the inventory, values are stored as JSON
+const (
+	bucketMeta = "meta"
+	bucketCameras = "cameras"
+	bucketPresets = "presets"
+	bucketTours = "tours"
+	bucketSettings = "settings"
+)
+
+var storeBuckets = []string{bucketMeta, bucketCameras, bucketPresets, bucketTours, bucketSettings}
+
+// Inventory of the server, memory only until the store of the config file is opened
+var gStore = NewMemoryStore()
+
+// Version of the inventory layout, see migrateStore
+const storeVersion = 2
+
+// Store is a key value storage of JSON values grouped in buckets
+type Store interface {
+	Get(bucket string, key string) (json.RawMessage, error)
+	Put(bucket string, key string, value interface{}) error
+	Delete(bucket string, key string) error
+	List(bucket string) (map[string]json.RawMessage, error)
+	Close() error
+}
+
+type StoreConfig struct {
+	// json or bolt
+	Type string `yaml:"type"`
+	Path string `yaml:"path"`
+}
+
+// jsonStore keeps all buckets in memory and writes them to a JSON file on each change,
+// it is memory only without path
+type jsonStore struct {
+	path string
+	buckets map[string]map[string]json.RawMessage
+	lock *sync.RWMutex
+}
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+// Internal
+
+func newJSONStore(path string) (*jsonStore, error) {
+	store := &jsonStore{path: path, buckets: make(map[string]map[string]json.RawMessage), lock: new(sync.RWMutex)}
+
+	if path == "" {
+		return store, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &store.buckets); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Write the file atomically, a crash leaves the old or the new file. The file is
+// only readable by the owner
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path) + ".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (store *jsonStore) save() error {
+	if store.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(store.buckets, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(store.path, data)
+}
+
+func (store *jsonStore) Get(bucket string, key string) (json.RawMessage, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	value, ok := store.buckets[bucket][key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s/%s", ErrNotFound, bucket, key)
+	}
+
+	return value, nil
+}
+
+func (store *jsonStore) Put(bucket string, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if store.buckets[bucket] == nil {
+		store.buckets[bucket] = make(map[string]json.RawMessage)
+	}
+	store.buckets[bucket][key] = data
+
+	return store.save()
+}
+
+func (store *jsonStore) Delete(bucket string, key string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, ok := store.buckets[bucket][key]; !ok {
+		return fmt.Errorf("%w: %s/%s", ErrNotFound, bucket, key)
+	}
+	delete(store.buckets[bucket], key)
+
+	return store.save()
+}
+
+func (store *jsonStore) List(bucket string) (map[string]json.RawMessage, error) {
+	store.lock.RLock()
+	defer store.lock.RUnlock()
+
+	values := make(map[string]json.RawMessage, len(store.buckets[bucket]))
+	for key, value := range store.buckets[bucket] {
+		values[key] = value
+	}
+
+	return values, nil
+}
+
+func (store *jsonStore) Close() error {
+	return nil
+}
+
+func newBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range storeBuckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+func (store *boltStore) Get(bucket string, key string) (json.RawMessage, error) {
+	var value json.RawMessage
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("%w: %s/%s", ErrNotFound, bucket, key)
+		}
+
+		data := b.Get([]byte(key))
+		if data == nil {
+			return fmt.Errorf("%w: %s/%s", ErrNotFound, bucket, key)
+		}
+
+		// data is only valid in the transaction
+		value = append(json.RawMessage{}, data...)
+		return nil
+	})
+
+	return value, err
+}
+
+func (store *boltStore) Put(bucket string, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return store.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+func (store *boltStore) Delete(bucket string, key string) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil || b.Get([]byte(key)) == nil {
+			return fmt.Errorf("%w: %s/%s", ErrNotFound, bucket, key)
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (store *boltStore) List(bucket string) (map[string]json.RawMessage, error) {
+	values := make(map[string]json.RawMessage)
+
+	err := store.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(key []byte, data []byte) error {
+			values[string(key)] = append(json.RawMessage{}, data...)
+			return nil
+		})
+	})
+
+	return values, err
+}
+
+func (store *boltStore) Close() error {
+	return store.db.Close()
+}
+
+// Migrate the inventory to storeVersion, the cameras of the config file which are not in
+// the inventory yet are added with their passwords moved to secrets. Cameras of the inventory
+// are edited with the REST API and not overwritten by the config file
+func migrateStore(store Store, secrets *Secrets, config Config) error {
+	version := 0
+	if data, err := store.Get(bucketMeta, "version"); err == nil {
+		if err := json.Unmarshal(data, &version); err != nil {
+			return err
+		}
+	}
+
+	if version > storeVersion {
+		return fmt.Errorf("inventory version %d is newer than %d", version, storeVersion)
+	}
+
+	// version 1: cameras, presets, tours and settings buckets
+
+	// version 2: passwords of the cameras are moved to secrets
+	if version < 2 {
+		records, err := store.List(bucketCameras)
+		if err != nil {
+			return err
+		}
+
+		for id, data := range records {
+			var record cameraRecord
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+			if record.Password != "" {
+				if err := secrets.Put(id, record.Password); err != nil {
+					return err
+				}
+			}
+			if err := store.Put(bucketCameras, id, record.CameraConfig); err != nil {
+				return err
+			}
+		}
+	}
+
+	// passwords of the new cameras of the config file
+	var moved []string
+	for _, camera := range config.Cameras {
+		if _, err := store.Get(bucketCameras, camera.Id); err == nil {
+			continue
+		}
+		if camera.Password != "" {
+			if err := secrets.Put(camera.Id, camera.Password); err != nil {
+				return err
+			}
+			moved = append(moved, camera.Id)
+		}
+		if err := store.Put(bucketCameras, camera.Id, camera); err != nil {
+			return err
+		}
+	}
+	if len(moved) > 0 {
+		logWarn("Passwords of cameras " + strings.Join(moved, ", ") + " are kept in the secrets file, they can be removed from " + config.Path)
+	}
+
+	if version < storeVersion {
+		fmt.Printf("Inventory migrated from version %d to %d\n", version, storeVersion)
+		return store.Put(bucketMeta, "version", storeVersion)
+	}
+
+	return nil
+}
+
+// Interface for Outside
+
+// OpenStore opens the inventory of the config, the default is the JSON file inventory.json
+func OpenStore(config StoreConfig) (Store, error) {
+	path := config.Path
+
+	switch config.Type {
+	case "", "json":
+		if path == "" {
+			path = "inventory.json"
+		}
+		return newJSONStore(path)
+	case "bolt":
+		if path == "" {
+			path = "inventory.db"
+		}
+		return newBoltStore(path)
+	}
+
+	return nil, fmt.Errorf("%w: store type %q", ErrInvalidArgument, config.Type)
+}
+
+// NewMemoryStore is a store without persistence
+func NewMemoryStore() Store {
+	store, _ := newJSONStore("")
+	return store
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Output of f on stdout
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	f()
+	os.Stdout = stdout
+	w.Close()
+
+	data, _ := io.ReadAll(r)
+	return string(data)
+}
+
+func TestMigrateStorePasswords(t *testing.T) {
+	gServer.LogLevel = LogWarn
+	defer func() { gServer.LogLevel = LogError }()
+
+	store := NewMemoryStore()
+	secrets := NewMemorySecrets()
+	config := Config{
+		Path: "/etc/ptz/cameras.yaml",
+		Cameras: []CameraConfig{
+			{Id: "gate", Ip: "192.0.2.31", Port: 80, Username: "admin", Password: "secret"},
+			{Id: "door", Ip: "192.0.2.32", Port: 80},
+		},
+	}
+
+	var err error
+	out := captureStdout(t, func() { err = migrateStore(store, secrets, config) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secrets.Get("gate") != "secret" {
+		t.Error("password not moved to secrets")
+	}
+	if data, _ := store.Get(bucketCameras, "gate"); strings.Contains(string(data), "secret") {
+		t.Errorf("password in the store: %s", data)
+	}
+	if strings.Count(out, "Passwords of cameras gate are kept") != 1 || !strings.Contains(out, config.Path) {
+		t.Errorf("warning of the first start: %q", out)
+	}
+
+	// a camera edited with the REST API is kept on the next start
+	edited := config.Cameras[0]
+	edited.Ip = "192.0.2.33"
+	if err := store.Put(bucketCameras, edited.Id, edited); err != nil {
+		t.Fatal(err)
+	}
+	if err := secrets.Put(edited.Id, "changed"); err != nil {
+		t.Fatal(err)
+	}
+
+	out = captureStdout(t, func() { err = migrateStore(store, secrets, config) })
+	if err != nil || strings.Contains(out, "Passwords") {
+		t.Errorf("next start: %v, %q", err, out)
+	}
+	data, _ := store.Get(bucketCameras, "gate")
+	if !strings.Contains(string(data), "192.0.2.33") || secrets.Get("gate") != "changed" {
+		t.Errorf("edited camera overwritten: %s", data)
+	}
+}
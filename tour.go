@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// States of the tour engine
+const (
+	TourStopped = "stopped"
+	TourRunning = "running"
+	TourPaused = "paused"
+)
+
+// Interval of GetStatus while waiting for the end of a step move
+const tourPollInterval = 500 * time.Millisecond
+
+// Maximum time of a step move, the tour continues if the camera still reports moving
+const tourMoveTimeout = 60 * time.Second
+
+// TourStep moves to Preset, or to the absolute position Pan/Tilt/Zoom if Preset is empty,
+// and stays there for Dwell seconds
+type TourStep struct {
+	Preset string `json:"preset"`
+	Pan float64 `json:"Pan"`
+	Tilt float64 `json:"Tilt"`
+	Zoom float64 `json:"Zoom"`
+	// 0 ~ 1.0, 0 means the default speed
+	Speed float64 `json:"Speed"`
+	Dwell float64 `json:"Dwell"`
+}
+
+type Tour struct {
+	Name string `json:"name"`
+	Steps []TourStep `json:"steps"`
+	// number of rounds, 0 means until stopped
+	Loops int `json:"loops"`
+}
+
+type TourName struct {
+	Name string `json:"name"`
+}
+
+type TourStatus struct {
+	Name string
+	State string
+	Step int
+	Loop int
+	// paused by a manual move
+	Yielded bool
+	Error string
+}
+
+// TourEngine runs tours of a PTZControl, a move requested through the PTZControl
+// interface pauses the running tour
+type TourEngine struct {
+	ptz *PTZControl
+	// tours are stored by owner/name, the owner is the camera address
+	store Store
+	owner string
+	status TourStatus
+	lock *sync.Mutex
+	resume chan struct{}
+	cancel context.CancelFunc
+	done chan struct{}
+}
+
+// Internal
+
+func validateTour(tour Tour) error {
+	if tour.Name == "" {
+		return fmt.Errorf("%w: tour name is required", ErrInvalidArgument)
+	}
+
+	if len(tour.Steps) == 0 {
+		return fmt.Errorf("%w: tour %s has no steps", ErrInvalidArgument, tour.Name)
+	}
+
+	if tour.Loops < 0 {
+		return fmt.Errorf("%w: tour loops %d", ErrOutOfRange, tour.Loops)
+	}
+
+	for i, step := range tour.Steps {
+		if step.Speed < 0 || step.Speed > 1 {
+			return fmt.Errorf("%w: speed of step %d", ErrOutOfRange, i)
+		}
+		if step.Dwell < 0 {
+			return fmt.Errorf("%w: dwell of step %d", ErrOutOfRange, i)
+		}
+	}
+
+	return nil
+}
+
+func (engine *TourEngine) paused() bool {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	return engine.status.State == TourPaused
+}
+
+// Wait while the tour is paused, false if the tour is stopped
+func (engine *TourEngine) waitResumed(ctx context.Context) bool {
+	for engine.paused() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-engine.resume:
+		}
+	}
+
+	return ctx.Err() == nil
+}
+
+// Sleep for d unless the tour is stopped or paused, false if the tour is stopped
+func (engine *TourEngine) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case <-ticker.C:
+			if engine.paused() {
+				return true
+			}
+		}
+	}
+}
+
+func (engine *TourEngine) execute(ctx context.Context, step TourStep) error {
+	dev := engine.ptz.cam
+	token := engine.ptz.token()
+
+	var err error
+	if step.Preset != "" {
+		_, err = gotoPreset(ctx, dev, token, step.Preset, step.Speed)
+	} else {
+		speed := step.Speed
+		if speed == 0 {
+			speed = 1
+		}
+		err = gotoPosition(ctx, dev, token, step.Pan, step.Tilt, step.Zoom, speed, speed, speed)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// wait until the camera is idle, the move may not be reported at once
+	deadline := time.Now().Add(tourMoveTimeout)
+	for time.Now().Before(deadline) {
+		if !engine.sleep(ctx, tourPollInterval) || engine.paused() {
+			return nil
+		}
+
+		status, err := getStatus(ctx, dev)
+		if err != nil {
+			return err
+		}
+		if !status.Moving {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (engine *TourEngine) run(ctx context.Context, tour Tour, done chan struct{}) {
+	defer func() {
+		engine.lock.Lock()
+		engine.status.State = TourStopped
+		engine.lock.Unlock()
+		close(done)
+	}()
+
+	for loop := 0; tour.Loops == 0 || loop < tour.Loops; loop++ {
+		for i, step := range tour.Steps {
+			if !engine.waitResumed(ctx) {
+				return
+			}
+
+			engine.lock.Lock()
+			engine.status.Step = i
+			engine.status.Loop = loop
+			engine.lock.Unlock()
+
+			err := engine.execute(ctx, step)
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				logWarn("Tour " + tour.Name + " step error:", err)
+				engine.lock.Lock()
+				engine.status.Error = err.Error()
+				engine.lock.Unlock()
+			}
+
+			if !engine.sleep(ctx, time.Duration(step.Dwell * float64(time.Second))) {
+				return
+			}
+		}
+	}
+}
+
+// Stop the running tour and wait for its end
+func (engine *TourEngine) stop() {
+	engine.lock.Lock()
+	cancel, done := engine.cancel, engine.done
+	engine.cancel, engine.done = nil, nil
+	engine.lock.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
+
+// Pause the running tour on a manual move
+func (engine *TourEngine) yield() {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	if engine.status.State == TourRunning {
+		engine.status.State = TourPaused
+		engine.status.Yielded = true
+	}
+}
+
+// Interface for Outside
+
+func NewTourEngine(ptz *PTZControl, store Store) *TourEngine {
+	engine := &TourEngine{
+		ptz: ptz,
+		store: store,
+		owner: fmt.Sprintf("%s:%d", ptz.info.Ip, ptz.info.Port),
+		status: TourStatus{State: TourStopped},
+		lock: new(sync.Mutex),
+		resume: make(chan struct{}, 1),
+	}
+
+	return engine
+}
+
+// Running is true while the tour moves the camera, a paused tour is not running
+func (engine *TourEngine) Running() bool {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	return engine.status.State == TourRunning
+}
+
+func (engine *TourEngine) GetTours() (map[string]interface{}, error) {
+	records, err := engine.store.List(bucketTours)
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get tours", "data": nil}, err
+	}
+
+	tours := make([]Tour, 0)
+	for key, data := range records {
+		if !strings.HasPrefix(key, engine.owner + "/") {
+			continue
+		}
+
+		var tour Tour
+		if err := json.Unmarshal(data, &tour); err != nil {
+			logWarn("Cannot load tour " + key + ":", err)
+			continue
+		}
+		tours = append(tours, tour)
+	}
+	sort.Slice(tours, func(i, j int) bool { return tours[i].Name < tours[j].Name })
+
+	engine.lock.Lock()
+	status := engine.status
+	engine.lock.Unlock()
+
+	return map[string]interface{}{"code": 200, "message": "Tours", "data": map[string]interface{}{"Tours": tours, "Status": status}}, nil
+}
+
+// SaveTour adds or replaces a tour, a running tour is changed with its next start
+func (engine *TourEngine) SaveTour(tour Tour) (map[string]interface{}, error) {
+	if err := validateTour(tour); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": err.Error(), "data": nil}, err
+	}
+
+	if err := engine.store.Put(bucketTours, engine.owner + "/" + tour.Name, tour); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot save tour", "data": nil}, err
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Save tour", "data": TourName{Name: tour.Name}}, nil
+}
+
+func (engine *TourEngine) RemoveTour(name string) (map[string]interface{}, error) {
+	if err := engine.store.Delete(bucketTours, engine.owner + "/" + name); err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot remove tour", "data": nil}, err
+	}
+
+	engine.lock.Lock()
+	running := engine.status.State != TourStopped && engine.status.Name == name
+	engine.lock.Unlock()
+
+	if running {
+		engine.stop()
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Remove tour", "data": TourName{Name: name}}, nil
+}
+
+// Start runs the tour from its first step, a running tour is stopped
+func (engine *TourEngine) Start(name string) (map[string]interface{}, error) {
+	if !engine.ptz.connected {
+		return not_connected(), ErrNotConnected
+	}
+
+	var tour Tour
+	data, err := engine.store.Get(bucketTours, engine.owner + "/" + name)
+	if err == nil {
+		err = json.Unmarshal(data, &tour)
+	}
+
+	if err != nil {
+		return map[string]interface{}{"code": errorCode(err), "message": "Cannot get tour", "data": nil}, err
+	}
+
+	engine.stop()
+
+	// the tour outlives the request which started it
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	engine.lock.Lock()
+	engine.status = TourStatus{Name: name, State: TourRunning}
+	engine.cancel, engine.done = cancel, done
+	engine.lock.Unlock()
+
+	go engine.run(ctx, tour, done)
+
+	return map[string]interface{}{"code": 200, "message": "Start tour", "data": TourName{Name: name}}, nil
+}
+
+func (engine *TourEngine) Pause() (map[string]interface{}, error) {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	if engine.status.State == TourStopped {
+		err := fmt.Errorf("%w: no running tour", ErrNotFound)
+		return map[string]interface{}{"code": errorCode(err), "message": "No running tour", "data": nil}, err
+	}
+
+	engine.status.State = TourPaused
+
+	return map[string]interface{}{"code": 200, "message": "Pause tour", "data": engine.status}, nil
+}
+
+// Resume continues a paused tour with its next step
+func (engine *TourEngine) Resume() (map[string]interface{}, error) {
+	engine.lock.Lock()
+	defer engine.lock.Unlock()
+
+	if engine.status.State == TourStopped {
+		err := fmt.Errorf("%w: no running tour", ErrNotFound)
+		return map[string]interface{}{"code": errorCode(err), "message": "No running tour", "data": nil}, err
+	}
+
+	engine.status.State = TourRunning
+	engine.status.Yielded = false
+
+	select {
+	case engine.resume <- struct{}{}:
+	default:
+	}
+
+	return map[string]interface{}{"code": 200, "message": "Resume tour", "data": engine.status}, nil
+}
+
+func (engine *TourEngine) Stop() (map[string]interface{}, error) {
+	engine.stop()
+
+	return map[string]interface{}{"code": 200, "message": "Stop tour", "data": nil}, nil
+}